@@ -1,10 +1,20 @@
 package billing
 
 import (
+	"context"
+	"log"
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/events"
 	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/payments/numbering"
 	"github.com/neghi-go/payments/processors"
+	"github.com/neghi-go/payments/utils"
 )
 
 type BillingContext struct {
@@ -12,10 +22,83 @@ type BillingContext struct {
 	Card         database.Model[models.Card]
 	Invoice      database.Model[models.Invoice]
 	Transactions database.Model[models.Transaction]
+	Links        database.Model[models.PaymentLink]
 	Processor    processors.Processor
+	Events       *events.Bus
+	Numbering    *numbering.Sequence
+	Clock        clock.Clock
+	Leases       *lease.Leases
+
+	before_charge []ChargeHook
+	after_payment []PaymentHook
+	reference     func() string
+}
+
+// Publish sends e on the event bus. The state change it describes has already
+// been stored, so subscriber errors are logged rather than returned.
+func (c *BillingContext) Publish(ctx context.Context, e events.Event) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = c.Clock.Now()
+	}
+	if err := c.Events.Publish(ctx, e); err != nil {
+		log.Printf("billing: publishing %s: %v", e.Type, err)
+	}
 }
 
+// CustomerOf loads the customer an invoice or card belongs to, by its
+// CustomerID. Load it before changing anything, every event about the change
+// carries it.
+func (c *BillingContext) CustomerOf(id uuid.UUID) (*models.Customer, error) {
+	return c.Customer.Query(database.WithFilter("id", id)).First()
+}
+
+// SetReferences replaces the generator NewReference uses. References must be
+// unique with the payment processor.
+func (c *BillingContext) SetReferences(generate func() string) {
+	c.reference = generate
+}
+
+// NewReference returns a reference for a new transaction, 12 random bytes
+// unless a module set its own generator.
+func (c *BillingContext) NewReference() string {
+	if c.reference == nil {
+		return utils.GenerateReference(12)
+	}
+	return c.reference()
+}
+
+// LockInvoice takes the invoice's lease, returning lease.ErrHeld while another
+// request or worker holds it. Every path that changes an invoice or starts a
+// payment on it should hold the lease, re-read the invoice once it has it and
+// do its work under held, which is cancelled if the lease is lost.
+func (c *BillingContext) LockInvoice(ctx context.Context, id uuid.UUID) (held context.Context, release func(), err error) {
+	return c.Leases.Acquire(ctx, "invoice:"+id.String())
+}
+
+// Billing is a module mounted under /<Name>. Every field but Name is optional.
 type Billing struct {
 	Name string
 	Init func(r chi.Router, ctx *BillingContext)
+	// Collections are registered on the shared connection before Init is
+	// called. Build fails when two modules claim the same name.
+	Collections []Collection
+	// Middleware wraps every route of the module.
+	Middleware []func(http.Handler) http.Handler
+	// Start is called once all modules are mounted, in registration order, and
+	// must not block. A failing Start aborts Payments.Build.
+	Start func(ctx context.Context, bctx *BillingContext) error
+	// Stop is called by Payments.Close, in reverse registration order.
+	Stop func(ctx context.Context) error
+	// BeforeCharge and AfterPayment are attached to the BillingContext shared
+	// by all modules, see OnBeforeCharge and OnAfterPayment.
+	BeforeCharge ChargeHook
+	AfterPayment PaymentHook
+	// Reference, when set, generates the transaction references of every
+	// module, see SetReferences.
+	Reference func() string
+	// Err reports a configuration mistake found by the module's constructor.
+	// Payments.Build returns it before connecting to the database.
+	Err error
+
+	pass func(ctx context.Context, bctx *BillingContext) error
 }
@@ -0,0 +1,136 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/processors"
+)
+
+var (
+	// ErrNoCard is returned by ChargeCard when the customer has no saved card.
+	ErrNoCard = errors.New("billing: customer has no saved card")
+	// ErrCardNotFound is returned by ChargeCard when the requested card is
+	// not one of the customer's.
+	ErrCardNotFound = errors.New("billing: card not found")
+	// ErrCardInactive is returned by ChargeCard when the requested card has
+	// expired or been deactivated.
+	ErrCardInactive = errors.New("billing: card is no longer active")
+)
+
+// CustomerCards returns the customer's cards, the default one first and the
+// rest most recently used first.
+func (c *BillingContext) CustomerCards(customer_id uuid.UUID) ([]*models.Card, error) {
+	cards, err := c.Card.Query(database.WithFilter("customer_id", customer_id)).All()
+	if err != nil {
+		return nil, err
+	}
+	models.SortCards(cards)
+	return cards, nil
+}
+
+// ChargeCard picks the card to charge the customer with: card_id when it is
+// set, otherwise the default card, otherwise the most recently used one.
+// Expired and deactivated cards are skipped.
+func (c *BillingContext) ChargeCard(customer_id, card_id uuid.UUID) (*models.Card, error) {
+	now := c.Clock.Now()
+	if card_id != uuid.Nil {
+		card, err := c.Card.Query(database.WithFilter("id", card_id)).First()
+		if err != nil || card.CustomerID != customer_id {
+			return nil, ErrCardNotFound
+		}
+		if !card.Usable(now) {
+			return nil, ErrCardInactive
+		}
+		return card, nil
+	}
+	cards, err := c.CustomerCards(customer_id)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range cards {
+		if card.Usable(now) {
+			return card, nil
+		}
+	}
+	return nil, ErrNoCard
+}
+
+// saveCard stores the card trx was paid with when the processor can reuse it.
+// A card the customer already has is only marked used, a new one becomes the
+// default when they have no usable default. The payment is already recorded, so
+// failures are logged.
+func (c *BillingContext) saveCard(ctx context.Context, customer *models.Customer, trx *models.Transaction) {
+	authorizer, ok := c.Processor.(processors.Authorizer)
+	if !ok {
+		return
+	}
+	auth, err := authorizer.Authorization(ctx, trx.Reference)
+	if err != nil {
+		log.Printf("billing: fetching card for %s: %v", trx.Reference, err)
+		return
+	}
+	if auth == nil {
+		return
+	}
+
+	cards, err := c.CustomerCards(customer.ID)
+	if err != nil {
+		log.Printf("billing: saving card for %s: %v", trx.Reference, err)
+		return
+	}
+	has_default := false
+	for _, card := range cards {
+		has_default = has_default || (card.Default && card.Usable(c.Clock.Now()))
+		if card.AuthKey == auth.AuthKey {
+			card.LastUsed = c.Clock.Now()
+			if err := Update(c.Card, card); err != nil {
+				log.Printf("billing: saving card for %s: %v", trx.Reference, err)
+			}
+			c.linkCustomer(customer, auth, trx)
+			return
+		}
+	}
+
+	card := &models.Card{
+		ID:         uuid.New(),
+		CustomerID: customer.ID,
+		AuthKey:    auth.AuthKey,
+		Brand:      auth.Brand,
+		Last4:      auth.Last4,
+		ExpMonth:   auth.ExpMonth,
+		ExpYear:    auth.ExpYear,
+		Bank:       auth.Bank,
+		Default:    !has_default,
+		CreatedAt:  c.Clock.Now(),
+		LastUsed:   c.Clock.Now(),
+	}
+	if err := c.Card.Save(*card); err != nil {
+		log.Printf("billing: saving card for %s: %v", trx.Reference, err)
+		return
+	}
+	c.linkCustomer(customer, auth, trx)
+	c.Publish(ctx, events.Event{Type: events.CardSaved, Customer: customer, Card: card})
+}
+
+// linkCustomer marks the customer as having a card and records their
+// processor reference when it wasn't known yet.
+func (c *BillingContext) linkCustomer(customer *models.Customer, auth *processors.Card, trx *models.Transaction) {
+	changed := !customer.HasCard
+	customer.HasCard = true
+	if customer.ProcessorID == "" && auth.CustomerCode != "" {
+		customer.ProcessorID = auth.CustomerCode
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if err := Update(c.Customer, customer); err != nil {
+		log.Printf("billing: saving customer for %s: %v", trx.Reference, err)
+	}
+}
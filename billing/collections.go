@@ -0,0 +1,50 @@
+package billing
+
+import (
+	"fmt"
+
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/database/mongodb"
+)
+
+// Collection is a model a module keeps in a collection of its own. Declare
+// one with Declare and list it in Billing.Collections.
+type Collection interface {
+	Name() string
+	// Bind registers the model on con, the connection opened by Payments.Build.
+	Bind(con *mongodb.Backend) error
+}
+
+// Handle is a declared collection, its Model is usable from Init and Start on.
+type Handle[T any] struct {
+	name  string
+	model database.Model[T]
+}
+
+// Declare names the collection T is stored in. Names are shared by every
+// module, so prefix them with the module's own.
+func Declare[T any](name string) *Handle[T] {
+	return &Handle[T]{name: name}
+}
+
+func (h *Handle[T]) Name() string {
+	return h.name
+}
+
+// Model panics when called before the handle is bound.
+func (h *Handle[T]) Model() database.Model[T] {
+	if h.model == nil {
+		panic(fmt.Sprintf("billing: collection %q used before Payments.Build", h.name))
+	}
+	return h.model
+}
+
+func (h *Handle[T]) Bind(con *mongodb.Backend) error {
+	var doc T
+	model, err := mongodb.RegisterModel(con, h.name, doc)
+	if err != nil {
+		return fmt.Errorf("billing: registering %s: %w", h.name, err)
+	}
+	h.model = model
+	return nil
+}
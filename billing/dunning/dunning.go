@@ -0,0 +1,285 @@
+package dunning
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+)
+
+var day = time.Hour * 24
+
+type Option func(*Dunning)
+
+// Dunning retries failed saved-card charges on a fixed schedule, measured from
+// the moment an invoice enters dunning, until it is paid or attempts run out.
+// An InvoiceReminder event is published ahead of every retry and
+// InvoiceUncollectible once it gives up.
+type Dunning struct {
+	schedule     []time.Duration
+	max_attempts int64
+	interval     time.Duration
+	batch        int
+}
+
+// WithSchedule sets the retry offsets, e.g. 1, 3, 5 and 7 days after the first failure.
+func WithSchedule(schedule ...time.Duration) Option {
+	return func(d *Dunning) {
+		d.schedule = schedule
+	}
+}
+
+// WithMaxAttempts caps the total charge attempts on an invoice, the initial
+// one included. An invoice's own MaxAttempts applies as well.
+func WithMaxAttempts(attempts int64) Option {
+	return func(d *Dunning) {
+		d.max_attempts = attempts
+	}
+}
+
+// WithInterval sets how often the engine looks for invoices due a retry.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Dunning) {
+		d.interval = interval
+	}
+}
+
+// WithBatch sets how many due invoices a pass loads at a time, 100 by default.
+func WithBatch(batch int) Option {
+	return func(d *Dunning) {
+		d.batch = batch
+	}
+}
+
+func New(opts ...Option) *Dunning {
+	cfg := &Dunning{
+		schedule: []time.Duration{day, day * 3, day * 5, day * 7},
+		interval: time.Hour,
+		batch:    100,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.max_attempts == 0 {
+		cfg.max_attempts = int64(len(cfg.schedule)) + 1
+	}
+	if cfg.batch <= 0 {
+		cfg.batch = 100
+	}
+	return cfg
+}
+
+// Billing runs the engine as a background module.
+func (d *Dunning) Billing() *billing.Billing {
+	return (&billing.Billing{Name: "dunning"}).Every(d.interval, d.RunOnce)
+}
+
+// RunOnce makes a single pass over the issued invoices due a retry, a batch
+// at a time. Each batch is one query for invoices whose NextRetry has come,
+// ordered by it, and one for their transactions. The next batch starts past
+// the last invoice seen.
+func (d *Dunning) RunOnce(ctx context.Context, bctx *billing.BillingContext) error {
+	now := bctx.Clock.Now()
+	var last *models.Invoice
+	for {
+		filter := billing.Filter{"status": models.InvIssued}.
+			Where("next_retry", "$gt", time.Time{}).
+			Where("next_retry", "$lte", now)
+		if last != nil {
+			filter.Or(
+				billing.Filter{}.Where("next_retry", "$gt", last.NextRetry),
+				billing.Filter{"next_retry": last.NextRetry}.Where("id", "$gt", last.ID),
+			)
+		}
+		opts := append(filter.Options(),
+			billing.Sort("next_retry", false),
+			billing.Sort("id", false),
+			database.WithLimit(int64(d.batch)),
+		)
+		due, err := bctx.Invoice.Query(opts...).All()
+		if err != nil {
+			return err
+		}
+		ids := make([]uuid.UUID, 0, len(due))
+		for _, invoice := range due {
+			ids = append(ids, invoice.ID)
+		}
+		tranx, err := bctx.InvoicesTransactions(ids)
+		if err != nil {
+			return err
+		}
+		for _, invoice := range due {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var latest *models.Transaction
+			if list := tranx[invoice.ID]; len(list) > 0 {
+				latest = list[len(list)-1]
+			}
+			if err := d.process(ctx, bctx, invoice, latest, now); err != nil {
+				log.Printf("dunning: invoice %s: %v", invoice.ID, err)
+			}
+		}
+		if len(due) < d.batch {
+			return nil
+		}
+		last = due[len(due)-1]
+	}
+}
+
+// exhausted reports whether the invoice has no charge attempts left, by the
+// engine's cap or its own.
+func (d *Dunning) exhausted(invoice *models.Invoice) bool {
+	return invoice.AttemptsExhausted() || invoice.AttemptCount >= d.max_attempts
+}
+
+// retryAt is when the invoice's next scheduled retry is due. Past the end of
+// the schedule it is the last one, so the next pass gives up on the invoice.
+func (d *Dunning) retryAt(invoice *models.Invoice) time.Time {
+	step := min(invoice.DunningStep, int64(len(d.schedule))-1)
+	return invoice.DunningStart.Add(d.schedule[step])
+}
+
+// process works on an invoice listed with latest, its most recent
+// transaction. latest only holds while the invoice is unchanged, starting a
+// payment on it bumps its version.
+func (d *Dunning) process(ctx context.Context, bctx *billing.BillingContext, listed *models.Invoice, latest *models.Transaction, now time.Time) error {
+	if latest == nil || latest.Status != models.TrxFailed {
+		// a payment is under way, or was abandoned by the customer
+		return nil
+	}
+	ctx, release, err := bctx.LockInvoice(ctx, listed.ID)
+	if errors.Is(err, lease.ErrHeld) {
+		// picked up again on the next pass
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	invoice, err := bctx.Invoice.Query(database.WithFilter("id", listed.ID)).First()
+	if err != nil {
+		return err
+	}
+	if invoice.Status != models.InvIssued {
+		return nil
+	}
+	if invoice.Version != listed.Version {
+		if latest, err = bctx.LatestTransaction(invoice.ID); err != nil {
+			return err
+		}
+		if latest == nil || latest.Status != models.TrxFailed {
+			return nil
+		}
+	}
+	customer, err := bctx.Customer.Query(database.WithFilter("id", invoice.CustomerID)).First()
+	if err != nil {
+		return err
+	}
+
+	if invoice.DunningStart.IsZero() {
+		if len(d.schedule) == 0 || d.exhausted(invoice) {
+			return d.giveUp(ctx, bctx, customer, invoice)
+		}
+		// keep the invoice payable until the engine has run its course
+		invoice.DunningStart = now
+		invoice.DunningStep = 0
+		invoice.ExpiresAt = now.Add(d.schedule[len(d.schedule)-1] + d.interval)
+		invoice.NextRetry = d.retryAt(invoice)
+		if err := billing.Update(bctx.Invoice, invoice); err != nil {
+			return err
+		}
+		d.remind(ctx, bctx, customer, invoice, invoice.NextRetry)
+		return nil
+	}
+
+	if invoice.DunningStep >= int64(len(d.schedule)) || d.exhausted(invoice) {
+		return d.giveUp(ctx, bctx, customer, invoice)
+	}
+	if now.Before(d.retryAt(invoice)) {
+		return nil
+	}
+
+	card, err := bctx.ChargeCard(invoice.CustomerID, uuid.Nil)
+	if err != nil && !errors.Is(err, billing.ErrNoCard) {
+		return err
+	}
+	if err != nil {
+		// nothing to charge, the customer can still pay the invoice themselves
+		invoice.DunningStep += 1
+		invoice.NextRetry = d.retryAt(invoice)
+		if err := billing.Update(bctx.Invoice, invoice); err != nil {
+			return err
+		}
+		return d.next(ctx, bctx, customer, invoice)
+	}
+	if err := bctx.BeforeCharge(ctx, invoice, customer); err != nil {
+		// retried on the next pass, in case the hook's condition clears
+		return err
+	}
+
+	trx := models.NewTransaction(invoice.ID, bctx.NewReference(), now)
+	if err := bctx.Transactions.Save(*trx); err != nil {
+		return err
+	}
+	invoice.AttemptCount += 1
+	invoice.LastAttempt = now
+	invoice.DunningStep += 1
+	invoice.NextRetry = d.retryAt(invoice)
+	if err := billing.Update(bctx.Invoice, invoice); err != nil {
+		return err
+	}
+
+	if err := bctx.Processor.Charge(ctx, customer.Email, invoice.Amount, card.AuthKey, trx.Reference); err != nil {
+		trx.SetStatus(models.TrxFailed, now)
+		if err := billing.Update(bctx.Transactions, trx); err != nil {
+			return err
+		}
+		bctx.Publish(ctx, events.Event{Type: events.TransactionFailed, Customer: customer, Invoice: invoice, Transaction: trx})
+		return d.next(ctx, bctx, customer, invoice)
+	}
+	res, err := bctx.Processor.Verify(ctx, trx.Reference)
+	if err != nil {
+		// left pending, the next verify settles it
+		return err
+	}
+	if err := bctx.Settle(ctx, customer, invoice, trx, res); err != nil {
+		return err
+	}
+	if trx.Status == models.TrxFailed || trx.Status == models.TrxAbandonned {
+		return d.next(ctx, bctx, customer, invoice)
+	}
+	return nil
+}
+
+// next reminds the customer of the upcoming retry, or gives up when there is none.
+func (d *Dunning) next(ctx context.Context, bctx *billing.BillingContext, customer *models.Customer, invoice *models.Invoice) error {
+	if invoice.DunningStep >= int64(len(d.schedule)) || d.exhausted(invoice) {
+		return d.giveUp(ctx, bctx, customer, invoice)
+	}
+	d.remind(ctx, bctx, customer, invoice, invoice.NextRetry)
+	return nil
+}
+
+func (d *Dunning) remind(ctx context.Context, bctx *billing.BillingContext, customer *models.Customer, invoice *models.Invoice, next time.Time) {
+	bctx.Publish(ctx, events.Event{Type: events.InvoiceReminder, Customer: customer, Invoice: invoice, NextAttempt: next})
+}
+
+func (d *Dunning) giveUp(ctx context.Context, bctx *billing.BillingContext, customer *models.Customer, invoice *models.Invoice) error {
+	if err := invoice.Transition(models.InvUncollectible, "dunning", "retry schedule exhausted", bctx.Clock.Now()); err != nil {
+		return err
+	}
+	invoice.NextRetry = time.Time{}
+	if err := billing.Update(bctx.Invoice, invoice); err != nil {
+		return err
+	}
+	bctx.Publish(ctx, events.Event{Type: events.InvoiceUncollectible, Customer: customer, Invoice: invoice})
+	return nil
+}
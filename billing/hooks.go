@@ -0,0 +1,105 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// ChargeHook runs before a payment is started on invoice. Returning an error
+// refuses the charge and the error is shown to the caller.
+type ChargeHook func(ctx context.Context, invoice *models.Invoice, customer *models.Customer) error
+
+// PaymentHook runs once a transaction has paid its invoice.
+type PaymentHook func(ctx context.Context, invoice *models.Invoice, trx *models.Transaction)
+
+// OnBeforeCharge adds a hook run ahead of every charge, by any module. Hooks
+// must be added before the router serves requests, from Init or Start.
+func (c *BillingContext) OnBeforeCharge(hook ChargeHook) {
+	c.before_charge = append(c.before_charge, hook)
+}
+
+// OnAfterPayment adds a hook run after every successful payment, by any module.
+func (c *BillingContext) OnAfterPayment(hook PaymentHook) {
+	c.after_payment = append(c.after_payment, hook)
+}
+
+// BeforeCharge runs the charge hooks in order, stopping at the first error.
+func (c *BillingContext) BeforeCharge(ctx context.Context, invoice *models.Invoice, customer *models.Customer) error {
+	for _, hook := range c.before_charge {
+		if err := hook(ctx, invoice, customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AfterPayment runs the payment hooks in order.
+func (c *BillingContext) AfterPayment(ctx context.Context, invoice *models.Invoice, trx *models.Transaction) {
+	for _, hook := range c.after_payment {
+		hook(ctx, invoice, trx)
+	}
+}
+
+// Every sets Start and Stop to run pass every interval in the background.
+// Under a clock.TestClock nothing runs on its own, passes are made through
+// Pass each time the clock is advanced.
+func (b *Billing) Every(interval time.Duration, pass func(ctx context.Context, bctx *BillingContext) error) *Billing {
+	var (
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	)
+	b.pass = pass
+	b.Start = func(ctx context.Context, bctx *BillingContext) error {
+		if _, ok := bctx.Clock.(*clock.TestClock); ok {
+			return nil
+		}
+		ctx, cancel = context.WithCancel(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := pass(ctx, bctx); err != nil {
+						log.Printf("%s: %v", b.Name, err)
+					}
+				}
+			}
+		}()
+		return nil
+	}
+	b.Stop = func(ctx context.Context) error {
+		if cancel != nil {
+			cancel()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return b
+}
+
+// Pass runs the module's background work once, if it has any.
+func (b *Billing) Pass(ctx context.Context, bctx *BillingContext) error {
+	if b.pass == nil {
+		return nil
+	}
+	return b.pass(ctx, bctx)
+}
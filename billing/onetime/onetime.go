@@ -1,17 +1,21 @@
 package onetime
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"text/template"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/neghi-go/database"
 	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/events"
 	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
 	"github.com/neghi-go/payments/processors"
-	"github.com/neghi-go/payments/utils"
 	"github.com/neghi-go/utilities"
 )
 
@@ -24,16 +28,23 @@ var (
 type Options func(*depositBilling) error
 
 type depositBilling struct {
-	reference_length int
-	notify           func() error
+	expiry       time.Duration
+	max_attempts int64
+	reference    func() string
+	description  *template.Template
+	min_amount   int64
+	max_amount   int64
+	hooks        []InvoiceHook
 }
 
+// NewDepositBilling reports invalid options through the module's Err, so
+// Payments.Build fails on them.
 func NewDepositBilling(opts ...Options) *billing.Billing {
-	cfg := &depositBilling{
-		reference_length: 12,
-	}
+	cfg, err := newConfig(opts...)
 	return &billing.Billing{
-		Name: "onetime",
+		Name:      "onetime",
+		Err:       err,
+		Reference: cfg.reference,
 		Init: func(r chi.Router, ctx *billing.BillingContext) {
 			r.Post("/charge", func(w http.ResponseWriter, r *http.Request) {
 				var (
@@ -47,6 +58,8 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 					CustomerID string `json:"customer_id"`
 					Amount     int64  `json:"amount"`
 					InvoiceID  string `json:"invoice_id"`
+					// CardID picks a saved card, the default one is charged without it
+					CardID string `json:"card_id"`
 				}
 
 				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -64,38 +77,50 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 
 				switch Action(action) {
 				case initialize:
-					//create a new invoice
-					invoice = &models.Invoice{
-						ID:           uuid.New(),
-						CustomerID:   uuid.MustParse(body.CustomerID),
-						Description:  "description",
-						Amount:       body.Amount,
-						Status:       models.InvIssued,
-						AttemptCount: 1,
-						PaidAt:       time.Time{},
-						LastAttempt:  time.Now().UTC(),
-						ExpiresAt:    time.Now().Add(time.Hour * 24).UTC(),
+					if err := cfg.checkAmount(body.Amount); err != nil {
+						utilities.JSON(w).SetMessage(err.Error()).
+							SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusBadRequest).Send()
+						return
 					}
-					if err := ctx.Invoice.Save(*invoice); err != nil {
+					//create a new invoice
+					if invoice, err = cfg.newInvoice(customer, body.Amount, ctx.Clock.Now()); err != nil {
 						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusInternalServerError).Send()
 						return
 					}
+					for _, hook := range cfg.hooks {
+						if err := hook(r.Context(), invoice, customer); err != nil {
+							utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).Send()
+							return
+						}
+					}
+					if err := ctx.BeforeCharge(r.Context(), invoice, customer); err != nil {
+						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).Send()
+						return
+					}
 
 					//create a new transaction
-					trx = &models.Transaction{
-						ID:        uuid.New(),
-						InvoiceID: invoice.ID,
-						Status:    models.TrxPending,
-						Reference: utils.GenerateReference(cfg.reference_length),
-					}
-					if err := ctx.Transactions.Save(*trx); err != nil {
+					trx = models.NewTransaction(invoice.ID, cfg.reference(), ctx.Clock.Now())
+					unit := ctx.Unit(r.Context())
+					billing.NumberIn(unit, ctx.Numbering, invoice, invoice.CreatedAt)
+					billing.SaveIn(unit, ctx.Invoice, invoice)
+					billing.SaveIn(unit, ctx.Transactions, trx)
+					if err := unit.Commit(); err != nil {
 						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusInternalServerError).Send()
 						return
 					}
+					ctx.Publish(r.Context(), events.Event{Type: events.InvoiceIssued, Customer: customer, Invoice: invoice, Transaction: trx})
 					amount = invoice.Amount
 				default:
+					held, release, ok := lock(w, r, ctx, uuid.MustParse(body.InvoiceID))
+					if !ok {
+						return
+					}
+					defer release()
+					r = r.WithContext(held)
 					//check for invoice status
 					//if status is not paid, canceled, proceed
 					invoice, err = ctx.Invoice.Query(database.WithFilter("id", uuid.MustParse(body.InvoiceID))).First()
@@ -110,37 +135,40 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 							SetStatusCode(http.StatusOK).Send()
 						return
 					}
-					if invoice.Status == models.InvExpired || invoice.Status == models.InvCancelled {
+					if invoice.Status == models.InvExpired || invoice.Status == models.InvCancelled ||
+						invoice.Status == models.InvVoid || invoice.Status == models.InvUncollectible {
 						utilities.JSON(w).SetMessage("This invoice is no longer valid!").SetStatus(utilities.ResponseFail).
 							SetStatusCode(http.StatusBadRequest).Send()
 						return
 					}
 
 					if invoice.Status == models.InvIssued {
-						if time.Now().Unix() > invoice.ExpiresAt.Unix() {
-							invoice.Status = models.InvExpired
-							if err := ctx.Invoice.Query(database.WithFilter("id", invoice.ID)).Update(*invoice); err != nil {
+						if ctx.Clock.Now().Unix() > invoice.ExpiresAt.Unix() {
+							if err := invoice.Transition(models.InvExpired, "system", "invoice expired", ctx.Clock.Now()); err != nil {
 								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusNotFound).Send()
+									SetStatusCode(http.StatusConflict).Send()
 								return
 							}
+							if err := billing.Update(ctx.Invoice, invoice); err != nil {
+								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+									SetStatusCode(billing.StatusCode(err, http.StatusNotFound)).Send()
+								return
+							}
+							ctx.Publish(r.Context(), events.Event{Type: events.InvoiceExpired, Customer: customer, Invoice: invoice})
 							utilities.JSON(w).SetMessage("Invoice is Expired, please try again").SetStatus(utilities.ResponseFail).
 								SetStatusCode(http.StatusBadRequest).Send()
 							return
 						}
 						//check state of last transaction
 						//if status is not pending or success, proceed
-						tranx, err := ctx.Transactions.Query(
-							database.WithFilter("invoice_id", invoice.ID),
-						).All()
+						// finalized invoices start out without a transaction
+						trx, err = ctx.LatestTransaction(invoice.ID)
 						if err != nil {
 							utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
 								SetStatusCode(http.StatusBadRequest).Send()
 							return
 						}
-
-						trx = tranx[len(tranx)-1]
-						if trx.Status == models.TrxPending {
+						if trx != nil && trx.Status == models.TrxPending {
 							var res processors.VerifyState
 							//verify transaction and update accordingly
 							if res, err = ctx.Processor.Verify(r.Context(), trx.Reference); err != nil {
@@ -149,70 +177,52 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 								return
 							}
 
-							if res == processors.Success {
-								invoice.Status = models.InvPaid
-								invoice.PaidAt = time.Now().UTC()
-								trx.Status = models.TrxSuccess
-								if err := ctx.Invoice.Query(database.WithFilter("id", invoice.ID)).Update(*invoice); err != nil {
-									utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-										SetStatusCode(http.StatusBadRequest).Send()
-									return
-								}
-								if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-									utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-										SetStatusCode(http.StatusBadRequest).Send()
-									return
-								}
+							if err := ctx.Settle(r.Context(), customer, invoice, trx, res); err != nil {
+								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+									SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).Send()
+								return
+							}
+							switch res {
+							case processors.Success:
 								utilities.JSON(w).SetMessage("Invoice has been cleared!").SetStatus(utilities.ResponseSuccess).
 									SetStatusCode(http.StatusOK).Send()
 								return
-							}
-							if res == processors.Failed {
-								trx.Status = models.TrxFailed
-								if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-									utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-										SetStatusCode(http.StatusBadRequest).Send()
-									return
-								}
+							case processors.Failed:
 								utilities.JSON(w).SetMessage("Your Transaction Failed, Please try again").SetStatus(utilities.ResponseSuccess).
 									SetStatusCode(http.StatusOK).Send()
 								return
-							}
-							if res == processors.Abandoned {
-								trx.Status = models.TrxAbandonned
-								if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-									utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-										SetStatusCode(http.StatusBadRequest).Send()
-									return
-								}
+							case processors.Abandoned:
 								utilities.JSON(w).SetMessage("Your Transaction Could not be completed, Please try again").
 									SetStatus(utilities.ResponseSuccess).
 									SetStatusCode(http.StatusOK).Send()
 								return
-							}
-							if res == processors.Pending {
-								trx.Status = models.TrxPending
-								if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-									utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-										SetStatusCode(http.StatusBadRequest).Send()
-									return
-								}
+							case processors.Pending:
 								utilities.JSON(w).SetMessage("Your Transaction is still pending, please try again later").
 									SetStatus(utilities.ResponseSuccess).
 									SetStatusCode(http.StatusOK).Send()
 								return
 							}
 						}
-						if trx.Status == models.TrxFailed {
-							invoice.LastAttempt = time.Now().UTC()
+						if trx == nil || trx.Status == models.TrxFailed || trx.Status == models.TrxAbandonned {
+							if invoice.AttemptsExhausted() {
+								utilities.JSON(w).SetMessage("Maximum payment attempts reached for this invoice").
+									SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusBadRequest).Send()
+								return
+							}
+							if err := ctx.BeforeCharge(r.Context(), invoice, customer); err != nil {
+								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusBadRequest).Send()
+								return
+							}
+							invoice.LastAttempt = ctx.Clock.Now()
 							invoice.AttemptCount += 1
-							//create new trx
-							trx = &models.Transaction{
-								ID:        uuid.New(),
-								InvoiceID: invoice.ID,
-								Status:    models.TrxPending,
-								Reference: utils.GenerateReference(cfg.reference_length),
+							if err := billing.Update(ctx.Invoice, invoice); err != nil {
+								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+									SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).Send()
+								return
 							}
+							//create new trx
+							trx = models.NewTransaction(invoice.ID, cfg.reference(), ctx.Clock.Now())
 							if err := ctx.Transactions.Save(*trx); err != nil {
 								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
 									SetStatusCode(http.StatusBadRequest).Send()
@@ -229,21 +239,50 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 						}
 					}
 					if invoice.Status == models.InvDraft {
-						utilities.JSON(w).SetMessage("Invoice is not supported on this resource").SetStatus(utilities.ResponseFail).
+						utilities.JSON(w).SetMessage("Invoice is still a draft, finalize it before payment").SetStatus(utilities.ResponseFail).
 							SetStatusCode(http.StatusBadRequest).Send()
 						return
 					}
 
 				}
 				//check if user has a valid card, if yes, attempt to charge card else, generate payment url and redirect
-				validCard, err := ctx.Card.Query(database.WithFilter("customer_id", uuid.MustParse(body.CustomerID))).First()
+				card_id := uuid.Nil
+				if body.CardID != "" {
+					if card_id, err = uuid.Parse(body.CardID); err != nil {
+						utilities.JSON(w).SetMessage("Invalid card_id").SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).Send()
+						return
+					}
+				}
+				validCard, err := ctx.ChargeCard(customer.ID, card_id)
+				if errors.Is(err, billing.ErrCardNotFound) {
+					utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusNotFound).Send()
+					return
+				}
+				if errors.Is(err, billing.ErrCardInactive) {
+					utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusBadRequest).Send()
+					return
+				}
+				if err != nil && !errors.Is(err, billing.ErrNoCard) {
+					utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
+						SetStatusCode(http.StatusInternalServerError).Send()
+					return
+				}
 				if err != nil {
-					auth_url, err := ctx.Processor.Init(r.Context(), customer.Email, amount, trx.Reference)
+					auth_url, err := ctx.Processor.Init(r.Context(), customer.Email, amount, trx.Reference, "")
 					if err != nil {
 						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusInternalServerError).Send()
 						return
 					}
+					trx.AuthorizationURL = auth_url
+					if err := billing.Update(ctx.Transactions, trx); err != nil {
+						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
+							SetStatusCode(billing.StatusCode(err, http.StatusInternalServerError)).Send()
+						return
+					}
 					utilities.JSON(w).SetStatus(utilities.ResponseSuccess).
 						SetStatusCode(http.StatusOK).SetMessage(auth_url).Send()
 					return
@@ -259,6 +298,12 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 			r.Post("/verify/{id}", func(w http.ResponseWriter, r *http.Request) {
 				var err error
 				InvoiceID := r.PathValue("id")
+				held, release, ok := lock(w, r, ctx, uuid.MustParse(InvoiceID))
+				if !ok {
+					return
+				}
+				defer release()
+				r = r.WithContext(held)
 
 				invoice, err := ctx.Invoice.Query(database.WithFilter("id", uuid.MustParse(InvoiceID))).First()
 				if err != nil {
@@ -266,110 +311,76 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 						SetStatusCode(http.StatusNotFound).Send()
 					return
 				}
+				customer, err := ctx.CustomerOf(invoice.CustomerID)
+				if err != nil {
+					utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusNotFound).Send()
+					return
+				}
+
+				// a pending payment is verified before the invoice status is looked at,
+				// it may have landed after the invoice expired or was paid another way
+				trx, err := ctx.LatestTransaction(invoice.ID)
+				if err != nil {
+					utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusBadRequest).Send()
+					return
+				}
+				if trx != nil && trx.Status == models.TrxPending {
+					res, err := ctx.Processor.Verify(r.Context(), trx.Reference)
+					if err != nil {
+						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusInternalServerError).Send()
+						return
+					}
+					if err := ctx.Settle(r.Context(), customer, invoice, trx, res); err != nil {
+						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+							SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).Send()
+						return
+					}
+					verified(w, trx, res)
+					return
+				}
 
 				if invoice.Status == models.InvPaid {
 					utilities.JSON(w).SetMessage("Invoice has been Cleared Already!").SetStatus(utilities.ResponseSuccess).
 						SetStatusCode(http.StatusOK).Send()
 					return
 				}
-				if invoice.Status == models.InvExpired || invoice.Status == models.InvCancelled {
+				if invoice.Status == models.InvExpired || invoice.Status == models.InvCancelled ||
+					invoice.Status == models.InvVoid || invoice.Status == models.InvUncollectible {
 					utilities.JSON(w).SetMessage("This invoice is no longer valid!").SetStatus(utilities.ResponseFail).
 						SetStatusCode(http.StatusBadRequest).Send()
 					return
 				}
 
 				if invoice.Status == models.InvIssued {
-					if time.Now().Unix() > invoice.ExpiresAt.Unix() {
-						invoice.Status = models.InvExpired
-						if err := ctx.Invoice.Query(database.WithFilter("id", invoice.ID)).Update(*invoice); err != nil {
+					if ctx.Clock.Now().Unix() > invoice.ExpiresAt.Unix() {
+						if err := invoice.Transition(models.InvExpired, "system", "invoice expired", ctx.Clock.Now()); err != nil {
+							utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusConflict).Send()
+							return
+						}
+						if err := billing.Update(ctx.Invoice, invoice); err != nil {
 							utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-								SetStatusCode(http.StatusNotFound).Send()
+								SetStatusCode(billing.StatusCode(err, http.StatusNotFound)).Send()
 							return
 						}
+						ctx.Publish(r.Context(), events.Event{Type: events.InvoiceExpired, Customer: customer, Invoice: invoice})
 						utilities.JSON(w).SetMessage("Invoice is Expired, please try again").SetStatus(utilities.ResponseFail).
 							SetStatusCode(http.StatusBadRequest).Send()
 						return
 					}
-					//check state of last transaction
-					//if status is not pending or success, proceed
-					tranx, err := ctx.Transactions.Query(
-						database.WithFilter("invoice_id", invoice.ID),
-					).All()
-					if err != nil {
-						utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
+					if trx == nil {
+						utilities.JSON(w).SetMessage("No payment has been started for this invoice").SetStatus(utilities.ResponseFail).
 							SetStatusCode(http.StatusBadRequest).Send()
 						return
 					}
-
-					trx := tranx[len(tranx)-1]
 					if trx.Status == models.TrxFailed {
 						utilities.JSON(w).SetMessage("Transaction Failed, please try again").SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusInternalServerError).Send()
 						return
 					}
-					if trx.Status == models.TrxPending {
-						var res processors.VerifyState
-						//verify transaction and update accordingly
-						if res, err = ctx.Processor.Verify(r.Context(), trx.Reference); err != nil {
-							utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
-								SetStatusCode(http.StatusInternalServerError).Send()
-							return
-						}
-
-						if res == processors.Success {
-							invoice.Status = models.InvPaid
-							invoice.PaidAt = time.Now().UTC()
-							trx.Status = models.TrxSuccess
-							if err := ctx.Invoice.Query(database.WithFilter("id", invoice.ID)).Update(*invoice); err != nil {
-								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusBadRequest).Send()
-								return
-							}
-							if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusBadRequest).Send()
-								return
-							}
-							utilities.JSON(w).SetMessage("Invoice has been cleared!").SetStatus(utilities.ResponseSuccess).
-								SetStatusCode(http.StatusOK).Send()
-							return
-						}
-						if res == processors.Failed {
-							trx.Status = models.TrxFailed
-							if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusBadRequest).Send()
-								return
-							}
-							utilities.JSON(w).SetMessage("Your Transaction Failed, Please try again").SetStatus(utilities.ResponseSuccess).
-								SetStatusCode(http.StatusOK).Send()
-							return
-						}
-						if res == processors.Abandoned {
-							trx.Status = models.TrxAbandonned
-							if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusBadRequest).Send()
-								return
-							}
-							utilities.JSON(w).SetMessage("Your Transaction Could not be completed, Please try again").
-								SetStatus(utilities.ResponseSuccess).
-								SetStatusCode(http.StatusOK).Send()
-							return
-						}
-						if res == processors.Pending {
-							trx.Status = models.TrxPending
-							if err := ctx.Transactions.Query(database.WithFilter("id", trx.ID)).Update(*trx); err != nil {
-								utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseFail).
-									SetStatusCode(http.StatusBadRequest).Send()
-								return
-							}
-							utilities.JSON(w).SetMessage("Your Transaction is still pending, please try again later").
-								SetStatus(utilities.ResponseSuccess).
-								SetStatusCode(http.StatusOK).Send()
-							return
-						}
-					}
 					if trx.Status == models.TrxSuccess {
 						utilities.JSON(w).SetMessage("Invoice has been cleared!").SetStatus(utilities.ResponseSuccess).
 							SetStatusCode(http.StatusOK).Send()
@@ -377,7 +388,7 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 					}
 				}
 				if invoice.Status == models.InvDraft {
-					utilities.JSON(w).SetMessage("Invoice is not supported on this resource").SetStatus(utilities.ResponseFail).
+					utilities.JSON(w).SetMessage("Invoice is still a draft, finalize it before payment").SetStatus(utilities.ResponseFail).
 						SetStatusCode(http.StatusBadRequest).Send()
 					return
 				}
@@ -386,3 +397,46 @@ func NewDepositBilling(opts ...Options) *billing.Billing {
 		},
 	}
 }
+
+// verified answers a verify call with the outcome the processor reported for trx.
+func verified(w http.ResponseWriter, trx *models.Transaction, res processors.VerifyState) {
+	if trx.RefundDue {
+		utilities.JSON(w).SetMessage("Invoice was already settled, this payment will be refunded").
+			SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).Send()
+		return
+	}
+	switch res {
+	case processors.Success:
+		utilities.JSON(w).SetMessage("Invoice has been cleared!").SetStatus(utilities.ResponseSuccess).
+			SetStatusCode(http.StatusOK).Send()
+	case processors.Failed:
+		utilities.JSON(w).SetMessage("Your Transaction Failed, Please try again").SetStatus(utilities.ResponseSuccess).
+			SetStatusCode(http.StatusOK).Send()
+	case processors.Abandoned:
+		utilities.JSON(w).SetMessage("Your Transaction Could not be completed, Please try again").
+			SetStatus(utilities.ResponseSuccess).
+			SetStatusCode(http.StatusOK).Send()
+	default:
+		utilities.JSON(w).SetMessage("Your Transaction is still pending, please try again later").
+			SetStatus(utilities.ResponseSuccess).
+			SetStatusCode(http.StatusOK).Send()
+	}
+}
+
+// lock holds the invoice's lease until release is called. It answers 409 when
+// another request has it and reports whether the caller may go on, under the
+// held context.
+func lock(w http.ResponseWriter, r *http.Request, ctx *billing.BillingContext, id uuid.UUID) (held context.Context, release func(), ok bool) {
+	held, release, err := ctx.LockInvoice(r.Context(), id)
+	if errors.Is(err, lease.ErrHeld) {
+		utilities.JSON(w).SetMessage("Invoice is being processed by another request, please try again").
+			SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).Send()
+		return nil, nil, false
+	}
+	if err != nil {
+		utilities.JSON(w).SetMessage(err.Error()).SetStatus(utilities.ResponseError).
+			SetStatusCode(http.StatusInternalServerError).Send()
+		return nil, nil, false
+	}
+	return held, release, true
+}
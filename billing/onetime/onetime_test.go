@@ -1 +1,97 @@
 package onetime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var (
+	now      = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	customer = &models.Customer{ID: uuid.New(), FirstName: "Ada", Email: "ada@example.com"}
+)
+
+func config(t *testing.T, opts ...Options) *depositBilling {
+	t.Helper()
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestNewDepositBillingReportsInvalidOptions(t *testing.T) {
+	if b := NewDepositBilling(); b.Err != nil {
+		t.Fatalf("got %v for the defaults", b.Err)
+	}
+	b := NewDepositBilling(WithExpiry(-time.Hour), WithAmountLimits(10, 5))
+	if b.Err == nil {
+		t.Fatal("got no error for invalid options")
+	}
+}
+
+func TestNewInvoiceExpiresAfterTheConfiguredExpiry(t *testing.T) {
+	invoice, err := config(t, WithExpiry(2*time.Hour)).newInvoice(customer, 500, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !invoice.ExpiresAt.Equal(now.Add(2 * time.Hour)) {
+		t.Fatalf("expires at %v, want two hours after %v", invoice.ExpiresAt, now)
+	}
+	if invoice.Status != models.InvIssued || invoice.CustomerID != customer.ID || invoice.Amount != 500 {
+		t.Fatalf("got %+v", invoice)
+	}
+
+	invoice, _ = config(t).newInvoice(customer, 500, now)
+	if !invoice.ExpiresAt.Equal(now.Add(24 * time.Hour)) {
+		t.Fatalf("expires at %v, want a day by default", invoice.ExpiresAt)
+	}
+}
+
+func TestCheckAmountHonoursTheLimits(t *testing.T) {
+	cfg := config(t, WithAmountLimits(100, 500))
+	for amount, ok := range map[int64]bool{99: false, 100: true, 500: true, 501: false} {
+		if err := cfg.checkAmount(amount); (err == nil) != ok {
+			t.Errorf("%d: got %v", amount, err)
+		}
+	}
+	open := config(t, WithAmountLimits(100, 0))
+	if err := open.checkAmount(1 << 40); err != nil {
+		t.Errorf("got %v, want no upper bound", err)
+	}
+}
+
+func TestNewInvoiceCarriesTheAttemptLimit(t *testing.T) {
+	invoice, _ := config(t, WithMaxAttempts(2)).newInvoice(customer, 500, now)
+	if invoice.AttemptCount != 1 || invoice.AttemptsExhausted() {
+		t.Fatalf("got %d of %d attempts used up front", invoice.AttemptCount, invoice.MaxAttempts)
+	}
+	invoice.AttemptCount++
+	if !invoice.AttemptsExhausted() {
+		t.Fatal("the second attempt did not exhaust a limit of two")
+	}
+
+	unlimited, _ := config(t).newInvoice(customer, 500, now)
+	unlimited.AttemptCount = 100
+	if unlimited.AttemptsExhausted() {
+		t.Fatal("attempts ran out without a limit")
+	}
+}
+
+func TestNewInvoiceDescribesItself(t *testing.T) {
+	cfg := config(t, WithDescription("Deposit of {{.Invoice.Amount}} by {{.Customer.Email}}"))
+	invoice, err := cfg.newInvoice(customer, 500, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Deposit of 500 by ada@example.com"; invoice.Description != want {
+		t.Fatalf("got %q, want %q", invoice.Description, want)
+	}
+
+	broken := config(t, WithDescription("{{.Customer.Missing}}"))
+	if _, err := broken.newInvoice(customer, 500, now); err == nil {
+		t.Fatal("got no error for a template naming a missing field")
+	}
+}
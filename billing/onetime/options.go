@@ -0,0 +1,162 @@
+package onetime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/utils"
+)
+
+// InvoiceHook is called on every new invoice before it is saved. It may modify
+// the invoice; returning an error rejects the charge.
+type InvoiceHook func(ctx context.Context, invoice *models.Invoice, customer *models.Customer) error
+
+// WithExpiry sets how long an issued invoice stays payable.
+func WithExpiry(expiry time.Duration) Options {
+	return func(d *depositBilling) error {
+		if expiry <= 0 {
+			return errors.New("onetime: expiry must be positive")
+		}
+		d.expiry = expiry
+		return nil
+	}
+}
+
+// WithMaxAttempts caps the payment attempts on an invoice, 0 means unlimited.
+func WithMaxAttempts(attempts int64) Options {
+	return func(d *depositBilling) error {
+		if attempts < 0 {
+			return errors.New("onetime: max attempts cannot be negative")
+		}
+		d.max_attempts = attempts
+		return nil
+	}
+}
+
+// WithReferenceLength sets the number of random bytes in generated references.
+// Every module, dunning and checkout included, uses the same references.
+func WithReferenceLength(length int) Options {
+	return func(d *depositBilling) error {
+		if length <= 0 {
+			return errors.New("onetime: reference length must be positive")
+		}
+		d.reference = func() string {
+			return utils.GenerateReference(length)
+		}
+		return nil
+	}
+}
+
+// WithReferenceGenerator replaces the random transaction reference generator,
+// for every module. References must be unique with the payment processor.
+func WithReferenceGenerator(generate func() string) Options {
+	return func(d *depositBilling) error {
+		if generate == nil {
+			return errors.New("onetime: reference generator cannot be nil")
+		}
+		d.reference = generate
+		return nil
+	}
+}
+
+// WithDescription sets a text/template for invoice descriptions, executed
+// with the invoice as .Invoice and its customer as .Customer.
+func WithDescription(description string) Options {
+	return func(d *depositBilling) error {
+		tmpl, err := template.New("description").Parse(description)
+		if err != nil {
+			return err
+		}
+		d.description = tmpl
+		return nil
+	}
+}
+
+// WithAmountLimits bounds the amount of new invoices. Zero leaves that end
+// unbounded, which is the default.
+func WithAmountLimits(low, high int64) Options {
+	return func(d *depositBilling) error {
+		if low < 0 || (high != 0 && high < low) {
+			return errors.New("onetime: invalid amount limits")
+		}
+		d.min_amount = low
+		d.max_amount = high
+		return nil
+	}
+}
+
+func WithInvoiceHook(hook InvoiceHook) Options {
+	return func(d *depositBilling) error {
+		if hook == nil {
+			return errors.New("onetime: invoice hook cannot be nil")
+		}
+		d.hooks = append(d.hooks, hook)
+		return nil
+	}
+}
+
+func newConfig(opts ...Options) (*depositBilling, error) {
+	cfg := &depositBilling{
+		expiry: time.Hour * 24,
+		reference: func() string {
+			return utils.GenerateReference(12)
+		},
+		description: template.Must(template.New("description").Parse("description")),
+	}
+	var errs []error
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// checkAmount refuses an amount outside the configured limits.
+func (d *depositBilling) checkAmount(amount int64) error {
+	if amount < d.min_amount {
+		return fmt.Errorf("Amount must be at least %d", d.min_amount)
+	}
+	if d.max_amount != 0 && amount > d.max_amount {
+		return fmt.Errorf("Amount must not exceed %d", d.max_amount)
+	}
+	return nil
+}
+
+// newInvoice issues an invoice of amount to customer at now, its first
+// payment attempt starting right away.
+func (d *depositBilling) newInvoice(customer *models.Customer, amount int64, now time.Time) (*models.Invoice, error) {
+	invoice := &models.Invoice{
+		ID:           uuid.New(),
+		CustomerID:   customer.ID,
+		Amount:       amount,
+		Status:       models.InvIssued,
+		AttemptCount: 1,
+		MaxAttempts:  d.max_attempts,
+		LastAttempt:  now,
+		ExpiresAt:    now.Add(d.expiry),
+		CreatedAt:    now,
+	}
+	var err error
+	if invoice.Description, err = d.describe(invoice, customer); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+func (d *depositBilling) describe(invoice *models.Invoice, customer *models.Customer) (string, error) {
+	buf := &strings.Builder{}
+	if err := d.description.Execute(buf, struct {
+		Invoice  *models.Invoice
+		Customer *models.Customer
+	}{invoice, customer}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
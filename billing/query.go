@@ -0,0 +1,54 @@
+package billing
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/neghi-go/database"
+)
+
+// Filter is a MongoDB query document. database.WithFilter hands its value to
+// the server unchanged, so a field may hold an operator document such as
+// {"$lt": t}, and the $or key a list of documents. Every query that needs
+// more than equality goes through here.
+type Filter map[string]any
+
+// Where sets field to an operator document, merging it with the operators
+// already set on field, so a range can be built from its two ends.
+func (f Filter) Where(field, op string, value any) Filter {
+	cond, _ := f[field].(map[string]any)
+	if cond == nil {
+		cond = make(map[string]any)
+	}
+	cond[op] = value
+	f[field] = cond
+	return f
+}
+
+// Or requires at least one of the documents to match.
+func (f Filter) Or(docs ...Filter) Filter {
+	list := make([]map[string]any, 0, len(docs))
+	for _, doc := range docs {
+		list = append(list, doc)
+	}
+	f["$or"] = list
+	return f
+}
+
+// Options turns the filter into query options, one per field.
+func (f Filter) Options() []database.Options {
+	opts := make([]database.Options, 0, len(f))
+	for _, field := range slices.Sorted(maps.Keys(f)) {
+		opts = append(opts, database.WithFilter(field, f[field]))
+	}
+	return opts
+}
+
+// Sort orders a query by field, descending when desc is set. Repeat it to
+// break ties, earlier keys take precedence.
+func Sort(field string, desc bool) database.Options {
+	if desc {
+		return database.WithSort(field, -1)
+	}
+	return database.WithSort(field, 1)
+}
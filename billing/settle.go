@@ -0,0 +1,102 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/processors"
+)
+
+// conflict_retries is how often Settle reloads the invoice when it was
+// changed between being read and being marked paid.
+var conflict_retries = 3
+
+// Settle applies the result of a processor verification to a transaction and
+// its invoice. A paid invoice and its successful transaction are written in
+// one unit, see Unit. A success the invoice can't take, because another
+// payment settled it first or it was cancelled or voided, is still recorded
+// on the transaction, flagged RefundDue and published as TransactionOrphaned.
+// A failure on an issued invoice makes it due for dunning.
+// customer is the invoice's, for the events.
+func (c *BillingContext) Settle(ctx context.Context, customer *models.Customer, invoice *models.Invoice, trx *models.Transaction, res processors.VerifyState) error {
+	orphaned := false
+	switch res {
+	case processors.Success:
+		var err error
+		if orphaned, err = c.settlePaid(ctx, invoice, trx); err != nil {
+			return err
+		}
+	case processors.Failed:
+		trx.SetStatus(models.TrxFailed, c.Clock.Now())
+	case processors.Abandoned:
+		trx.SetStatus(models.TrxAbandonned, c.Clock.Now())
+	default:
+		return nil
+	}
+	if trx.Status != models.TrxSuccess {
+		if err := Update(c.Transactions, trx); err != nil {
+			return err
+		}
+	}
+	if trx.Status == models.TrxFailed && invoice.Status == models.InvIssued && invoice.NextRetry.IsZero() {
+		// hands the invoice to dunning, which schedules its retries from here
+		invoice.NextRetry = c.Clock.Now()
+		if err := Update(c.Invoice, invoice); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case orphaned:
+		c.Publish(ctx, events.Event{Type: events.TransactionOrphaned, Customer: customer, Invoice: invoice, Transaction: trx})
+	case trx.Status == models.TrxSuccess:
+		c.Publish(ctx, events.Event{Type: events.InvoicePaid, Customer: customer, Invoice: invoice, Transaction: trx})
+		c.saveCard(ctx, customer, trx)
+		c.AfterPayment(ctx, invoice, trx)
+	case trx.Status == models.TrxFailed:
+		c.Publish(ctx, events.Event{Type: events.TransactionFailed, Customer: customer, Invoice: invoice, Transaction: trx})
+	}
+	return nil
+}
+
+// settlePaid marks invoice paid by trx, reloading both when someone else
+// changed them in the meantime. It reports whether the invoice could not take
+// the payment, trx is then only recorded as a success due for refund.
+func (c *BillingContext) settlePaid(ctx context.Context, invoice *models.Invoice, trx *models.Transaction) (orphaned bool, err error) {
+	for attempt := 0; ; attempt++ {
+		if trx.Status == models.TrxSuccess {
+			// settled by an earlier verification
+			return trx.RefundDue, nil
+		}
+		trx.SetStatus(models.TrxSuccess, c.Clock.Now())
+		unit := c.Unit(ctx)
+		if models.CanTransition(invoice.Status, models.InvPaid) {
+			if err := invoice.Transition(models.InvPaid, "processor", "payment verified", c.Clock.Now()); err != nil {
+				return false, err
+			}
+			invoice.PaidAt = c.Clock.Now()
+			UpdateIn(unit, c.Invoice, invoice)
+		} else {
+			trx.RefundDue = true
+		}
+		UpdateIn(unit, c.Transactions, trx)
+		err := unit.Commit()
+		if !errors.Is(err, ErrConflict) || attempt == conflict_retries {
+			return trx.RefundDue, err
+		}
+
+		fresh, err := c.Invoice.Query(database.WithFilter("id", invoice.ID)).First()
+		if err != nil {
+			return false, err
+		}
+		*invoice = *fresh
+		fresh_trx, err := c.Transactions.Query(database.WithFilter("id", trx.ID)).First()
+		if err != nil {
+			return false, err
+		}
+		*trx = *fresh_trx
+	}
+}
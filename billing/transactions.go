@@ -0,0 +1,71 @@
+package billing
+
+import (
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// transactionFetcher loads the transactions of the given invoices in a
+// single query.
+type transactionFetcher func(invoice_ids []uuid.UUID) ([]*models.Transaction, error)
+
+func (c *BillingContext) fetchTransactions(invoice_ids []uuid.UUID) ([]*models.Transaction, error) {
+	return c.Transactions.Query(byInvoices(invoice_ids).Options()...).All()
+}
+
+// byInvoices matches the transactions of any of the invoices.
+func byInvoices(invoice_ids []uuid.UUID) Filter {
+	if len(invoice_ids) == 1 {
+		return Filter{"invoice_id": invoice_ids[0]}
+	}
+	return Filter{}.Where("invoice_id", "$in", invoice_ids)
+}
+
+// InvoiceTransactions returns the invoice's transactions, oldest first.
+func (c *BillingContext) InvoiceTransactions(invoice_id uuid.UUID) ([]*models.Transaction, error) {
+	tranx, err := c.fetchTransactions([]uuid.UUID{invoice_id})
+	if err != nil {
+		return nil, err
+	}
+	models.SortTransactions(tranx)
+	return tranx, nil
+}
+
+// InvoicesTransactions returns the transactions of every invoice, keyed by
+// invoice and oldest first, with one query however many invoices there are.
+// Invoices without transactions map to an empty slice.
+func (c *BillingContext) InvoicesTransactions(invoice_ids []uuid.UUID) (map[uuid.UUID][]*models.Transaction, error) {
+	return groupTransactions(invoice_ids, c.fetchTransactions)
+}
+
+func groupTransactions(invoice_ids []uuid.UUID, fetch transactionFetcher) (map[uuid.UUID][]*models.Transaction, error) {
+	grouped := make(map[uuid.UUID][]*models.Transaction, len(invoice_ids))
+	if len(invoice_ids) == 0 {
+		return grouped, nil
+	}
+	for _, id := range invoice_ids {
+		grouped[id] = make([]*models.Transaction, 0)
+	}
+	tranx, err := fetch(invoice_ids)
+	if err != nil {
+		return nil, err
+	}
+	// sorting once keeps every invoice's share in order
+	models.SortTransactions(tranx)
+	for _, trx := range tranx {
+		if list, ok := grouped[trx.InvoiceID]; ok {
+			grouped[trx.InvoiceID] = append(list, trx)
+		}
+	}
+	return grouped, nil
+}
+
+// LatestTransaction returns the invoice's most recent transaction, or nil
+// when no payment has been started on it.
+func (c *BillingContext) LatestTransaction(invoice_id uuid.UUID) (*models.Transaction, error) {
+	tranx, err := c.InvoiceTransactions(invoice_id)
+	if err != nil || len(tranx) == 0 {
+		return nil, err
+	}
+	return tranx[len(tranx)-1], nil
+}
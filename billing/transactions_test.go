@@ -0,0 +1,51 @@
+package billing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+func TestByInvoicesMatchesAnyOfTheInvoices(t *testing.T) {
+	one, two := uuid.New(), uuid.New()
+	if got, want := byInvoices([]uuid.UUID{one}), (Filter{"invoice_id": one}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	ids := []uuid.UUID{one, two}
+	want := Filter{"invoice_id": map[string]any{"$in": ids}}
+	if got := byInvoices(ids); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestGroupTransactionsKeysEveryInvoiceOldestFirst(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	paid, empty := uuid.New(), uuid.New()
+	later := models.NewTransaction(paid, "later", at.Add(time.Hour))
+	earlier := models.NewTransaction(paid, "earlier", at)
+	stray := models.NewTransaction(uuid.New(), "stray", at)
+
+	queries := 0
+	grouped, err := groupTransactions([]uuid.UUID{paid, empty}, func(ids []uuid.UUID) ([]*models.Transaction, error) {
+		queries++
+		return []*models.Transaction{later, stray, earlier}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries != 1 {
+		t.Fatalf("got %d queries, want 1", queries)
+	}
+	if got := grouped[paid]; len(got) != 2 || got[0] != earlier || got[1] != later {
+		t.Fatalf("got %v for the paid invoice", got)
+	}
+	if got, ok := grouped[empty]; !ok || len(got) != 0 {
+		t.Fatalf("got %v, want an empty list for the invoice without transactions", got)
+	}
+	if len(grouped) != 2 {
+		t.Fatalf("got %d invoices, want 2", len(grouped))
+	}
+}
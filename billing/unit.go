@@ -0,0 +1,185 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/numbering"
+)
+
+// Unit groups writes that should land together. Stage them with UpdateIn,
+// SaveIn, DeleteIn and NumberIn, nothing is written before Commit.
+//
+// A unit is not a transaction. database.Model writes take no context, so they
+// can't join a Mongo session. Writes are applied one by one and, when one
+// fails, those already made are compensated. Readers may see the partial
+// state in between, and a compensation never overwrites a document someone
+// changed since, it is reported instead.
+type Unit struct {
+	ctx   context.Context
+	steps []*step
+	// after runs once Commit is over, told whether it succeeded.
+	after []func(committed bool)
+}
+
+type step struct {
+	do   func() error
+	undo func() error
+}
+
+// Unit starts a unit of work for the request or pass ctx belongs to. Commit
+// stops before the next write once ctx is done.
+func (c *BillingContext) Unit(ctx context.Context) *Unit {
+	return &Unit{ctx: ctx}
+}
+
+func (u *Unit) add(do, undo func() error) {
+	u.steps = append(u.steps, &step{do: do, undo: undo})
+}
+
+func (u *Unit) finally(f func(committed bool)) {
+	u.after = append(u.after, f)
+}
+
+// Commit applies every staged write in order. When one fails, the writes
+// already made are undone in reverse, and an undo that fails too is reported
+// alongside the original error.
+func (u *Unit) Commit() (err error) {
+	defer func() {
+		for i := len(u.after) - 1; i >= 0; i-- {
+			u.after[i](err == nil)
+		}
+	}()
+	for i, s := range u.steps {
+		if err := u.ctx.Err(); err != nil {
+			return errors.Join(append([]error{err}, u.rollback(i)...)...)
+		}
+		if err := s.do(); err != nil {
+			return errors.Join(append([]error{err}, u.rollback(i)...)...)
+		}
+	}
+	return nil
+}
+
+// rollback undoes the first n steps, last first.
+func (u *Unit) rollback(n int) []error {
+	var errs []error
+	for j := n - 1; j >= 0; j-- {
+		if err := u.steps[j].undo(); err != nil {
+			errs = append(errs, fmt.Errorf("billing: rolling back: %w", err))
+		}
+	}
+	return errs
+}
+
+// UpdateIn stages a versioned update of doc, see Update. Undoing it puts the
+// previous copy back as it was, version and revision included, but only while
+// the update is still the stored one. It fails with a conflict rather than
+// overwrite a later change.
+func UpdateIn[T any, P versioned[T]](u *Unit, model database.Model[T], doc P) {
+	id, version, revision := doc.VersionRef()
+	var before T
+	u.add(func() error {
+		stored, err := model.Query(database.WithFilter("id", id)).First()
+		if err != nil {
+			return err
+		}
+		before = *stored
+		return Update(model, doc)
+	}, func() error {
+		_, written, token := doc.VersionRef()
+		if err := model.Query(
+			database.WithFilter("id", id),
+			database.WithFilter("version", written),
+			database.WithFilter("revision", token),
+		).Update(before); err != nil {
+			return err
+		}
+		stored, err := model.Query(database.WithFilter("id", id)).First()
+		if err != nil {
+			return err
+		}
+		if _, found, current := P(stored).VersionRef(); found != version || current != revision {
+			return &ConflictError{ID: id, Expected: written, Found: found}
+		}
+		doc.SetVersion(version, revision)
+		return nil
+	})
+}
+
+// SaveIn stages inserting doc. Undoing it deletes the document only while it
+// is still the one inserted.
+func SaveIn[T any, P versioned[T]](u *Unit, model database.Model[T], doc P) {
+	id, version, _ := doc.VersionRef()
+	token := uuid.NewString()
+	u.add(func() error {
+		doc.SetVersion(version, token)
+		return model.Save(*doc)
+	}, func() error {
+		if err := model.Query(database.WithFilter("id", id), database.WithFilter("revision", token)).Delete(); err != nil {
+			return err
+		}
+		stored, err := model.Query(database.WithFilter("id", id)).First()
+		if err == nil {
+			_, found, _ := P(stored).VersionRef()
+			return &ConflictError{ID: id, Expected: version, Found: found}
+		}
+		return nil
+	})
+}
+
+// DeleteIn stages deleting every document matching opts. Undoing it inserts
+// them again, which fails for any that was recreated in the meantime.
+func DeleteIn[T any](u *Unit, model database.Model[T], opts ...database.Options) {
+	var before []*T
+	u.add(func() error {
+		docs, err := model.Query(opts...).All()
+		if err != nil {
+			return err
+		}
+		before = docs
+		if len(docs) == 0 {
+			return nil
+		}
+		return model.Query(opts...).DeleteMany()
+	}, func() error {
+		var errs []error
+		for _, doc := range before {
+			if err := model.Save(*doc); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// NumberIn stages numbering invoice with the next number at at. Stage the
+// invoice's own write after it. The sequence stays locked until Commit is
+// over, so the number is only issued when the write landed, and no later
+// invoice can be numbered before it.
+func NumberIn(u *Unit, sequence *numbering.Sequence, invoice *models.Invoice, at time.Time) {
+	before := invoice.Number
+	u.add(func() error {
+		claim, err := sequence.Reserve(u.ctx, at)
+		if err != nil {
+			return err
+		}
+		invoice.Number = claim.Number
+		u.finally(func(committed bool) {
+			if err := sequence.Done(claim, committed); err != nil {
+				// the next claim looks the pending number up instead
+				log.Printf("billing: finishing number %s: %v", claim.Number, err)
+			}
+		})
+		return nil
+	}, func() error {
+		invoice.Number = before
+		return nil
+	})
+}
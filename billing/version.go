@@ -0,0 +1,95 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var ErrConflict = errors.New("billing: document was changed concurrently")
+
+// ConflictError reports an update based on a version that is no longer the
+// stored one. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	ID       uuid.UUID
+	Expected int64
+	Found    int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("billing: %s was changed concurrently, expected version %d but found %d", e.ID, e.Expected, e.Found)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+type versioned[T any] interface {
+	*T
+	models.Versioned
+}
+
+// Update stores doc if the stored copy still has doc's version, bumping it.
+// The write is filtered on the old version and stamps a fresh revision. The
+// database doesn't report whether the filter matched, so the revision is read
+// back: a concurrent update that slipped in between the check and the write
+// leaves someone else's revision there, and Update returns a conflict instead
+// of a silent no-op. On conflict doc keeps its old version and the caller
+// should reload it.
+func Update[T any, P versioned[T]](model database.Model[T], doc P) error {
+	id, version, _ := doc.VersionRef()
+	return swap(doc, func() (P, error) {
+		stored, err := model.Query(database.WithFilter("id", id)).First()
+		return P(stored), err
+	}, func() error {
+		return model.Query(database.WithFilter("id", id), database.WithFilter("version", version)).Update(*doc)
+	})
+}
+
+// swap is Update's compare-and-swap over read, which loads the stored copy,
+// and write, which stores doc only where the old version is still stored.
+func swap[T any, P versioned[T]](doc P, read func() (P, error), write func() error) error {
+	id, version, revision := doc.VersionRef()
+	stored, err := read()
+	if err != nil {
+		return err
+	}
+	if _, found, _ := stored.VersionRef(); found != version {
+		return &ConflictError{ID: id, Expected: version, Found: found}
+	}
+
+	token := uuid.NewString()
+	doc.SetVersion(version+1, token)
+	if err := write(); err != nil {
+		doc.SetVersion(version, revision)
+		return err
+	}
+	if stored, err = read(); err != nil {
+		return err
+	}
+	if _, found, landed := stored.VersionRef(); landed != token {
+		doc.SetVersion(version, revision)
+		return &ConflictError{ID: id, Expected: version, Found: found}
+	}
+	return nil
+}
+
+// IsDuplicate reports whether err is MongoDB refusing a write that breaks a
+// unique index.
+func IsDuplicate(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "E11000")
+}
+
+// StatusCode is the HTTP status to answer err with, 409 for a version
+// conflict and fallback for anything else.
+func StatusCode(err error, fallback int) int {
+	if errors.Is(err, ErrConflict) {
+		return http.StatusConflict
+	}
+	return fallback
+}
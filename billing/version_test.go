@@ -0,0 +1,107 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// cell is a single stored invoice whose writes, like the database's, only
+// apply while the filtered version is still stored.
+type cell struct {
+	stored models.Invoice
+}
+
+func (c *cell) read() (*models.Invoice, error) {
+	doc := c.stored
+	return &doc, nil
+}
+
+func (c *cell) write(expected int64, doc *models.Invoice) func() error {
+	return func() error {
+		if c.stored.Version == expected {
+			c.stored = *doc
+		}
+		return nil
+	}
+}
+
+func TestSwapRejectsStaleWrite(t *testing.T) {
+	c := &cell{stored: models.Invoice{ID: uuid.New(), Status: models.InvIssued}}
+	first, _ := c.read()
+	second, _ := c.read()
+
+	first.Status = models.InvPaid
+	if err := swap(first, c.read, c.write(0, first)); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	second.Status = models.InvCancelled
+	err := swap(second, c.read, c.write(0, second))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale write: got %v, want a conflict", err)
+	}
+	if c.stored.Status != models.InvPaid || c.stored.Version != 1 {
+		t.Fatalf("stored %s at version %d, want PAID at 1", c.stored.Status, c.stored.Version)
+	}
+	if second.Version != 0 {
+		t.Fatalf("rejected doc moved to version %d", second.Version)
+	}
+}
+
+func TestSwapDetectsWriteLostToARace(t *testing.T) {
+	c := &cell{stored: models.Invoice{ID: uuid.New(), Status: models.InvIssued}}
+	mine, _ := c.read()
+	mine.Status = models.InvPaid
+
+	// another writer bumps the version between the check and the write, so
+	// the filtered write matches nothing
+	write := func() error {
+		other := c.stored
+		other.SetVersion(1, "other")
+		other.Status = models.InvCancelled
+		c.stored = other
+		return c.write(0, mine)()
+	}
+	if err := swap(mine, c.read, write); !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+	if c.stored.Status != models.InvCancelled {
+		t.Fatalf("stored %s, want the concurrent CANCELLED", c.stored.Status)
+	}
+}
+
+func TestSwapKeepsTheVersionWhenTheWriteFails(t *testing.T) {
+	c := &cell{stored: models.Invoice{ID: uuid.New(), Status: models.InvIssued}}
+	doc, _ := c.read()
+	doc.SetVersion(0, "mine")
+	failed := errors.New("connection reset")
+	if err := swap(doc, c.read, func() error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the write's error", err)
+	}
+	if _, version, revision := doc.VersionRef(); version != 0 || revision != "mine" {
+		t.Fatalf("failed write left version %d revision %q", version, revision)
+	}
+}
+
+func TestStatusCodeOfConflicts(t *testing.T) {
+	conflict := &ConflictError{ID: uuid.New(), Expected: 1, Found: 2}
+	if got := StatusCode(conflict, http.StatusInternalServerError); got != http.StatusConflict {
+		t.Errorf("got %d for a conflict", got)
+	}
+	if got := StatusCode(errors.New("connection reset"), http.StatusBadGateway); got != http.StatusBadGateway {
+		t.Errorf("got %d, want the fallback", got)
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	dup := errors.New(`write exception: E11000 duplicate key error collection: payments.customer index: email_1 dup key: { email: "a@b.co" }`)
+	if !IsDuplicate(dup) {
+		t.Error("E11000 not recognised as a duplicate")
+	}
+	if IsDuplicate(nil) || IsDuplicate(errors.New("connection reset")) {
+		t.Error("other errors taken for duplicates")
+	}
+}
@@ -0,0 +1,110 @@
+// Package clock lets billing code read the time from a source tests can control.
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d has passed on the clock. stop cancels the call
+	// and reports whether it did so in time.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// Real returns the system clock, in UTC.
+func Real() Clock {
+	return realClock{}
+}
+
+// TestClock stands still until it is advanced. Background workers don't tick
+// under a test clock, every advance runs them once instead, so a test can
+// step through expiries and retries deterministically.
+//
+// Functions scheduled with AfterFunc run when an advance reaches them, in
+// order of their deadline and with the clock set to it, before the advance
+// returns. They run on the goroutine advancing the clock.
+type TestClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	at time.Time
+	f  func()
+}
+
+// NewTestClock returns a clock frozen at start.
+func NewTestClock(start time.Time) *TestClock {
+	return &TestClock{now: start.UTC()}
+}
+
+func (c *TestClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *TestClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		i := slices.Index(c.timers, t)
+		if i < 0 {
+			return false
+		}
+		c.timers = slices.Delete(c.timers, i, i+1)
+		return true
+	}
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *TestClock) Advance(d time.Duration) time.Time {
+	return c.Set(c.Now().Add(max(d, 0)))
+}
+
+// Set moves the clock to t, which can't be before the current time.
+func (c *TestClock) Set(t time.Time) time.Time {
+	t = t.UTC()
+	for {
+		c.mu.Lock()
+		next := -1
+		for i, timer := range c.timers {
+			if !timer.at.After(t) && (next < 0 || timer.at.Before(c.timers[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			if t.After(c.now) {
+				c.now = t
+			}
+			now := c.now
+			c.mu.Unlock()
+			return now
+		}
+		due := c.timers[next]
+		c.timers = slices.Delete(c.timers, next, next+1)
+		if due.at.After(c.now) {
+			c.now = due.at
+		}
+		c.mu.Unlock()
+		due.f()
+	}
+}
@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTestClockOnlyMovesForward(t *testing.T) {
+	start := time.Date(2026, 1, 1, 9, 0, 0, 0, time.FixedZone("WAT", 3600))
+	c := NewTestClock(start)
+	if now := c.Now(); !now.Equal(start) || now.Location() != time.UTC {
+		t.Fatalf("got %v, want %v in UTC", now, start)
+	}
+	if now := c.Advance(36 * time.Hour); !now.Equal(start.Add(36 * time.Hour)) {
+		t.Fatalf("got %v after advancing", now)
+	}
+	if now := c.Advance(-time.Hour); !now.Equal(start.Add(36 * time.Hour)) {
+		t.Fatalf("got %v, a negative advance moved the clock", now)
+	}
+	if now := c.Set(start); !now.Equal(start.Add(36 * time.Hour)) {
+		t.Fatalf("got %v, the clock was set back", now)
+	}
+	later := start.Add(72 * time.Hour)
+	if now := c.Set(later); !now.Equal(later) || c.Now() != now {
+		t.Fatalf("got %v, want %v", now, later)
+	}
+}
+
+func TestTestClockRunsTimersInOrderAsItAdvances(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewTestClock(start)
+	var fired []time.Duration
+	record := func() { fired = append(fired, c.Now().Sub(start)) }
+	c.AfterFunc(2*time.Minute, record)
+	c.AfterFunc(time.Minute, func() {
+		record()
+		c.AfterFunc(30*time.Second, record)
+	})
+	stop := c.AfterFunc(90*time.Second+time.Second, record)
+	if !stop() || stop() {
+		t.Fatal("stop should cancel a pending timer exactly once")
+	}
+
+	c.Advance(59 * time.Second)
+	if len(fired) != 0 {
+		t.Fatalf("fired %v before their deadline", fired)
+	}
+	if now := c.Advance(time.Hour); !now.Equal(start.Add(time.Hour + 59*time.Second)) {
+		t.Fatalf("got %v after advancing", now)
+	}
+	want := []time.Duration{time.Minute, 90 * time.Second, 2 * time.Minute}
+	if !slices.Equal(fired, want) {
+		t.Fatalf("fired at %v, want %v", fired, want)
+	}
+}
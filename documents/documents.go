@@ -0,0 +1,298 @@
+package documents
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// Branding is the merchant identity printed on every document.
+type Branding struct {
+	Name    string
+	Address []string
+	Email   string
+	// Logo is a PNG or JPEG image, drawn in the top right corner.
+	Logo         []byte
+	PrimaryColor Color
+	AccentColor  Color
+	// Currency is printed in front of amounts, which are in minor units. It
+	// must be printable in WinAnsi, so "NGN" rather than "₦".
+	Currency string
+	Footer   string
+}
+
+// Check returns ErrUnprintable when the branding holds text the documents
+// can't print.
+func (b Branding) Check() error {
+	for _, s := range append([]string{b.Name, b.Email, b.Currency, b.Footer}, b.Address...) {
+		if err := printable(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithDefaults fills in colours left unset.
+func (b Branding) WithDefaults() Branding {
+	if b.PrimaryColor == (Color{}) {
+		b.PrimaryColor = Color{33, 37, 41}
+	}
+	if b.AccentColor == (Color{}) {
+		b.AccentColor = Color{233, 236, 239}
+	}
+	return b
+}
+
+// columns of the line item table, amounts are right aligned on their x
+var (
+	col_description = margin
+	col_quantity    = 380.0
+	col_unit        = 465.0
+	col_amount      = page_width - margin
+	line_height     = 18.0
+)
+
+type document struct {
+	pdf
+	branding Branding
+	y        float64
+}
+
+func newDocument(branding Branding) (*document, error) {
+	if err := branding.Check(); err != nil {
+		return nil, err
+	}
+	d := &document{branding: branding.WithDefaults()}
+	if len(branding.Logo) > 0 {
+		if err := d.setLogo(branding.Logo); err != nil {
+			return nil, fmt.Errorf("documents: decoding logo: %w", err)
+		}
+	}
+	d.header()
+	return d, nil
+}
+
+func (d *document) header() {
+	d.newPage()
+	d.rect(0, page_height-90, page_width, 90, d.branding.PrimaryColor)
+	d.text(margin, page_height-55, 20, true, white, fit(d.branding.Name, 20, true, 300))
+	d.image(page_width-margin-120, page_height-15, 120, 60)
+	d.y = page_height - 120
+}
+
+// ensure starts a new page when fewer than h points are left above the footer.
+func (d *document) ensure(h float64) bool {
+	if d.y-h >= margin+20 {
+		return false
+	}
+	d.header()
+	return true
+}
+
+// details prints the merchant address on the left and the document's key
+// facts on the right, under a title.
+func (d *document) details(title string, facts [][2]string) {
+	top := d.y
+	d.text(margin, d.y, 9, true, black, d.branding.Name)
+	for _, line := range d.branding.Address {
+		d.y -= 12
+		d.text(margin, d.y, 9, false, grey, line)
+	}
+	if d.branding.Email != "" {
+		d.y -= 12
+		d.text(margin, d.y, 9, false, grey, d.branding.Email)
+	}
+	left := d.y
+
+	d.y = top
+	d.textRight(col_amount, d.y, 18, true, d.branding.PrimaryColor, title)
+	d.y -= 8
+	for _, fact := range facts {
+		d.y -= 13
+		d.textRight(col_amount-110, d.y, 9, false, grey, fact[0])
+		d.textRight(col_amount, d.y, 9, true, black, fact[1])
+	}
+	d.y = min(left, d.y) - 30
+}
+
+func (d *document) billTo(customer *models.Customer) {
+	if customer == nil {
+		return
+	}
+	d.text(margin, d.y, 9, true, grey, "BILL TO")
+	d.y -= 14
+	d.text(margin, d.y, 10, true, black, strings.TrimSpace(customer.FirstName+" "+customer.LastName))
+	d.y -= 13
+	d.text(margin, d.y, 9, false, black, customer.Email)
+	d.y -= 30
+}
+
+func (d *document) itemsHeader() {
+	d.rect(margin, d.y-6, page_width-2*margin, line_height, d.branding.AccentColor)
+	d.text(col_description+6, d.y, 9, true, black, "Description")
+	d.textRight(col_quantity, d.y, 9, true, black, "Qty")
+	d.textRight(col_unit, d.y, 9, true, black, "Unit price")
+	d.textRight(col_amount-6, d.y, 9, true, black, "Amount")
+	d.y -= line_height + 4
+}
+
+func (d *document) items(invoice *models.Invoice) {
+	items := invoice.LineItems
+	if len(items) == 0 {
+		items = []*models.LineItem{{
+			Description: invoice.Description,
+			Quantity:    1,
+			UnitAmount:  invoice.Amount - invoice.Tax,
+		}}
+	}
+	d.itemsHeader()
+	for _, item := range items {
+		if d.ensure(line_height) {
+			d.itemsHeader()
+		}
+		d.text(col_description+6, d.y, 9, false, black, fit(item.Description, 9, false, col_quantity-col_description-60))
+		d.textRight(col_quantity, d.y, 9, false, black, strconv.FormatInt(item.Quantity, 10))
+		d.textRight(col_unit, d.y, 9, false, black, d.money(item.UnitAmount))
+		d.textRight(col_amount-6, d.y, 9, false, black, d.money(item.Total()))
+		d.line(margin, d.y-6, col_amount, d.y-6, d.branding.AccentColor)
+		d.y -= line_height
+	}
+	d.y -= 6
+}
+
+func (d *document) totals(rows [][2]string) {
+	d.ensure(float64(len(rows)) * 16)
+	for i, row := range rows {
+		bold := i == len(rows)-1
+		d.textRight(col_unit, d.y, 10, bold, black, row[0])
+		d.textRight(col_amount-6, d.y, 10, bold, black, row[1])
+		d.y -= 16
+	}
+	d.y -= 20
+}
+
+func (d *document) history(transactions []*models.Transaction) {
+	if len(transactions) == 0 {
+		return
+	}
+	d.ensure(line_height * 3)
+	d.text(margin, d.y, 11, true, d.branding.PrimaryColor, "Payment history")
+	d.y -= line_height
+	header := func() {
+		d.rect(margin, d.y-6, page_width-2*margin, line_height, d.branding.AccentColor)
+		d.text(col_description+6, d.y, 9, true, black, "Reference")
+		d.textRight(col_amount-6, d.y, 9, true, black, "Status")
+		d.y -= line_height + 4
+	}
+	header()
+	for _, trx := range transactions {
+		if d.ensure(line_height) {
+			header()
+		}
+		d.text(col_description+6, d.y, 9, false, black, trx.Reference)
+		d.textRight(col_amount-6, d.y, 9, false, black, trx.Status)
+		d.line(margin, d.y-6, col_amount, d.y-6, d.branding.AccentColor)
+		d.y -= line_height
+	}
+}
+
+func (d *document) footer() {
+	for i, page := range d.pages {
+		fmt.Fprintf(page, "%s rg BT /F1 8 Tf %.2f %.2f Td (%s) Tj ET\n", grey, margin, margin-20.0, d.escape(d.branding.Footer))
+		label := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		fmt.Fprintf(page, "%s rg BT /F1 8 Tf %.2f %.2f Td (%s) Tj ET\n", grey, col_amount-width(label, 8, false), margin-20.0, label)
+	}
+}
+
+func (d *document) money(amount int64) string {
+	return d.branding.Money(amount)
+}
+
+// Money formats an amount in minor units, e.g. 123456 as "NGN 1,234.56".
+func (b Branding) Money(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	units := strconv.FormatInt(amount/100, 10)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	value := fmt.Sprintf("%s%s.%02d", sign, units, amount%100)
+	if b.Currency == "" {
+		return value
+	}
+	return b.Currency + " " + value
+}
+
+func date(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("02 Jan 2006")
+}
+
+func reference(invoice *models.Invoice) string {
+	if invoice.Numbered() {
+		return invoice.Number
+	}
+	return invoice.ID.String()[:8]
+}
+
+// RenderInvoice writes invoice as a PDF, including its line items, tax and
+// the transactions loaded onto it.
+func RenderInvoice(w io.Writer, branding Branding, customer *models.Customer, invoice *models.Invoice) error {
+	d, err := newDocument(branding)
+	if err != nil {
+		return err
+	}
+	d.details("INVOICE", [][2]string{
+		{"Invoice number", reference(invoice)},
+		{"Status", invoice.Status},
+		{"Issued", date(invoice.CreatedAt)},
+		{"Due", date(invoice.ExpiresAt)},
+		{"Paid", date(invoice.PaidAt)},
+	})
+	d.billTo(customer)
+	d.items(invoice)
+	d.totals([][2]string{
+		{"Subtotal", d.money(invoice.Amount - invoice.Tax)},
+		{"Tax", d.money(invoice.Tax)},
+		{"Total", d.money(invoice.Amount)},
+	})
+	d.history(invoice.Transactions)
+	d.footer()
+	_, err = d.WriteTo(w)
+	return err
+}
+
+// RenderReceipt writes a PDF receipt for a successful transaction on invoice.
+func RenderReceipt(w io.Writer, branding Branding, customer *models.Customer, invoice *models.Invoice, trx *models.Transaction) error {
+	if trx.Status != models.TrxSuccess {
+		return fmt.Errorf("documents: transaction %s has not succeeded", trx.ID)
+	}
+	d, err := newDocument(branding)
+	if err != nil {
+		return err
+	}
+	d.details("RECEIPT", [][2]string{
+		{"Receipt number", trx.Reference},
+		{"Invoice number", reference(invoice)},
+		{"Date paid", date(invoice.PaidAt)},
+	})
+	d.billTo(customer)
+	d.items(invoice)
+	d.totals([][2]string{
+		{"Subtotal", d.money(invoice.Amount - invoice.Tax)},
+		{"Tax", d.money(invoice.Tax)},
+		{"Amount paid", d.money(invoice.Amount)},
+	})
+	d.footer()
+	_, err = d.WriteTo(w)
+	return err
+}
@@ -0,0 +1,80 @@
+package documents
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+func invoice(items int) *models.Invoice {
+	at := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	inv := &models.Invoice{ID: uuid.New(), Number: "INV-0001", Status: models.InvPaid, CreatedAt: at, PaidAt: at}
+	for i := range items {
+		inv.LineItems = append(inv.LineItems, &models.LineItem{Description: fmt.Sprintf("Seat %d", i), Quantity: 1, UnitAmount: 2500})
+		inv.Amount += 2500
+	}
+	trx := models.NewTransaction(inv.ID, "ref-1", at)
+	trx.SetStatus(models.TrxSuccess, at)
+	inv.Transactions = []*models.Transaction{trx}
+	return inv
+}
+
+func TestRenderInvoice(t *testing.T) {
+	customer := &models.Customer{FirstName: "Ada", LastName: "Obi", Email: "ada@example.com"}
+	buf := &bytes.Buffer{}
+	if err := RenderInvoice(buf, Branding{Name: "Acme (Lagos)", Currency: "€"}, customer, invoice(80)); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	for _, want := range []string{"%PDF-1.4", "(Acme \\(Lagos\\))", "(INV-0001)", "(ada@example.com)", "\x80 25.00", "\x80 2,000.00", "Page 1 of 3", "Page 3 of 3", "%%EOF"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+}
+
+func TestRenderFailsOnUnprintableBranding(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := RenderInvoice(buf, Branding{Name: "Acme", Currency: "₦"}, nil, invoice(1))
+	if !errors.Is(err, ErrUnprintable) {
+		t.Fatalf("got %v, want ErrUnprintable", err)
+	}
+	if buf.Len() != 0 {
+		t.Error("wrote a document with replaced characters")
+	}
+}
+
+func TestRenderFoldsUnprintableCustomerText(t *testing.T) {
+	customer := &models.Customer{FirstName: "Ọlá", LastName: "Nguyễn", Email: "ola@example.com"}
+	inv := invoice(1)
+	inv.LineItems[0].Description = "会员 Seat"
+	buf := &bytes.Buffer{}
+	if err := RenderInvoice(buf, Branding{Name: "Acme"}, customer, inv); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"(Ol\xe1 Nguy\xean)", "(?? Seat)"} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+}
+
+func TestRenderReceiptNeedsASuccessfulTransaction(t *testing.T) {
+	inv := invoice(1)
+	pending := models.NewTransaction(inv.ID, "ref-2", inv.CreatedAt)
+	if err := RenderReceipt(&bytes.Buffer{}, Branding{Name: "Acme"}, nil, inv, pending); err == nil {
+		t.Fatal("rendered a receipt for a pending transaction")
+	}
+	buf := &bytes.Buffer{}
+	if err := RenderReceipt(buf, Branding{Name: "Acme", Currency: "NGN"}, nil, inv, inv.Transactions[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("(RECEIPT)")) || !bytes.Contains(buf.Bytes(), []byte("(NGN 25.00)")) {
+		t.Error("receipt lacks its title or amount")
+	}
+}
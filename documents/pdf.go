@@ -0,0 +1,301 @@
+package documents
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+)
+
+// A4 in points.
+const (
+	page_width  = 595.28
+	page_height = 841.89
+	margin      = 50.0
+)
+
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) String() string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+// Hex returns the colour in CSS hex notation.
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+var (
+	black = Color{0, 0, 0}
+	grey  = Color{110, 110, 110}
+	white = Color{255, 255, 255}
+)
+
+// ErrUnprintable is returned when the merchant's branding holds a character
+// the standard PDF fonts have no glyph for, such as ₦. Only WinAnsi
+// (Windows-1252) text can be printed; amounts should use the currency code
+// instead of its symbol. Customer text is never refused, see escape.
+var ErrUnprintable = errors.New("documents: character outside the WinAnsi set")
+
+type logo struct {
+	data          []byte
+	width, height int
+}
+
+// pdf is a minimal single-font PDF writer: text in Helvetica, filled
+// rectangles, lines and one JPEG image, spread over as many pages as needed.
+type pdf struct {
+	pages []*bytes.Buffer
+	logo  *logo
+}
+
+func (p *pdf) page() *bytes.Buffer {
+	if len(p.pages) == 0 {
+		p.newPage()
+	}
+	return p.pages[len(p.pages)-1]
+}
+
+func (p *pdf) newPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+}
+
+func (p *pdf) text(x, y, size float64, bold bool, c Color, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(p.page(), "%s rg BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", c, font, size, x, y, p.escape(s))
+}
+
+// textRight draws s so that it ends at x.
+func (p *pdf) textRight(x, y, size float64, bold bool, c Color, s string) {
+	p.text(x-width(s, size, bold), y, size, bold, c, s)
+}
+
+func (p *pdf) rect(x, y, w, h float64, c Color) {
+	fmt.Fprintf(p.page(), "%s rg %.2f %.2f %.2f %.2f re f\n", c, x, y, w, h)
+}
+
+func (p *pdf) line(x1, y1, x2, y2 float64, c Color) {
+	fmt.Fprintf(p.page(), "%s RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", c, x1, y1, x2, y2)
+}
+
+// setLogo accepts a JPEG or PNG. It is re-encoded as an RGB JPEG, which PDF
+// can embed as is.
+func (p *pdf) setLogo(data []byte) error {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Over)
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, rgba, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+	p.logo = &logo{data: buf.Bytes(), width: bounds.Dx(), height: bounds.Dy()}
+	return nil
+}
+
+// image draws the logo scaled to fit within w by h, anchored at its top-left corner.
+func (p *pdf) image(x, top, w, h float64) {
+	if p.logo == nil {
+		return
+	}
+	scale := min(w/float64(p.logo.width), h/float64(p.logo.height))
+	dw, dh := float64(p.logo.width)*scale, float64(p.logo.height)*scale
+	fmt.Fprintf(p.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im1 Do Q\n", dw, dh, x, top-dh)
+}
+
+func (p *pdf) WriteTo(w io.Writer) (int64, error) {
+	if len(p.pages) == 0 {
+		p.newPage()
+	}
+	buf := &bytes.Buffer{}
+	offsets := make([]int, 0)
+	object := func(body string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			buf.WriteString("stream\n")
+			buf.Write(stream)
+			buf.WriteString("\nendstream\n")
+		}
+		buf.WriteString("endobj\n")
+	}
+
+	// object numbers: 1 catalog, 2 page tree, 3-4 fonts, 5 logo, then a page
+	// and its content stream per page
+	first := 5
+	if p.logo != nil {
+		first = 6
+	}
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", first+i*2)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+	resources := "<< /Font << /F1 3 0 R /F2 4 0 R >> >>"
+	if p.logo != nil {
+		object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>",
+			p.logo.width, p.logo.height, len(p.logo.data)), p.logo.data)
+		resources = "<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << /Im1 5 0 R >> >>"
+	}
+	for i, content := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			page_width, page_height, resources, first+i*2+1), nil)
+		object(fmt.Sprintf("<< /Length %d >>", content.Len()), content.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.WriteTo(w)
+}
+
+// escape encodes s as a WinAnsi PDF string literal. Letters carrying accents
+// WinAnsi lacks lose them, Ọlá prints as Olá and Nguyễn as Nguyên, and
+// anything else unprintable, such as CJK, becomes a question mark.
+func (p *pdf) escape(s string) string {
+	b := &strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		default:
+			c, ok := encode(r)
+			if !ok {
+				c, _ = encode(fold(r))
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// encode returns the WinAnsi code of r, reporting whether it has one.
+func encode(r rune) (byte, bool) {
+	switch {
+	case r < 127 || r >= 160 && r <= 255:
+		return byte(r), true
+	case win_ansi[r] != 0:
+		return win_ansi[r], true
+	}
+	return 0, false
+}
+
+// printable returns ErrUnprintable for the first character of s that escape
+// would have to replace.
+func printable(s string) error {
+	for _, r := range s {
+		if _, ok := encode(r); !ok {
+			return fmt.Errorf("%w: %q in %q", ErrUnprintable, r, s)
+		}
+	}
+	return nil
+}
+
+// fold returns the letter r is written with in WinAnsi, or '?'.
+func fold(r rune) rune {
+	switch {
+	case r >= 0x100 && r < 0x100+rune(len(latin_extended)):
+		return latin_extended[r-0x100]
+	case r >= 0x1e00 && r < 0x1e00+rune(len(latin_additional)):
+		return latin_additional[r-0x1e00]
+	}
+	return '?'
+}
+
+// latin_extended and latin_additional map U+0100 to U+024F and U+1E00 to
+// U+1EFF to the nearest WinAnsi letter, dropping the accents it lacks, or '?'
+// where there is none.
+var latin_extended = []rune("" +
+	"AaAaAaCcCcCcCcDdDdEeEeEeEeEeGgGgGgGgHhHhIiIiIiIiIi??JjKk?LlLlLlL" +
+	"lLlNnNnNn?NnOoOoOoŒœRrRrRrSsSsSsŠšTtTtTtUuUuUuUuUuUuWwYyŸZzZzŽžs" +
+	"b?????????????????ƒ?????????????Oo?????????????Uu???????????????" +
+	"?????????????AaIiOoUuÜüÜüÜüÜü?ÄäAaÆæ??GgKkOoOo??j???Gg??NnÅåÆæØø" +
+	"AaAaEeEeIiIiOoOoRrRrUuUuSsTt??Hh??????AaEeÖöÕõOoOoYy????????????" +
+	"????????????????")
+
+var latin_additional = []rune("" +
+	"AaBbBbBbÇçDdDdDdDdDdEeEeEeEeEeFfGgHhHhHhHhHhIiÏïKkKkKkLlLlLlLlMm" +
+	"MmMmNnNnNnNnÕõÕõOoOoPpPpRrRrRrRrSsSsSsŠšSsTtTtTtTtUuUuUuUuUuVvVv" +
+	"WwWwWwWwWwXxXxYyZzZzZzhtwy??????AaAaÂâÂâÂâÂâAaAaAaAaAaAaEeEeEeÊê" +
+	"ÊêÊêÊêEeIiIiOoOoÔôÔôÔôÔôOoOoOoOoOoOoUuUuUuUuUuUuUuYyYyYyYy??????")
+
+// win_ansi holds the Windows-1252 codes that differ from Latin-1.
+var win_ansi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// Helvetica and Helvetica-Bold advance widths for ASCII 32-126, in 1/1000 em.
+var helvetica = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helvetica_bold = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+func width(s string, size float64, bold bool) float64 {
+	table := &helvetica
+	if bold {
+		table = &helvetica_bold
+	}
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += table[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// fit shortens s with an ellipsis until it is at most w wide.
+func fit(s string, size float64, bold bool, w float64) string {
+	if width(s, size, bold) <= w {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && width(string(runes)+"...", size, bold) > w {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+type Type string
+
+const (
+	// All subscribes a handler to every event type.
+	All Type = "*"
+
+	InvoiceIssued        Type = "invoice.issued"
+	InvoicePaid          Type = "invoice.paid"
+	InvoiceExpired       Type = "invoice.expired"
+	InvoiceReminder      Type = "invoice.reminder"
+	InvoiceUncollectible Type = "invoice.uncollectible"
+	InvoiceCancelled     Type = "invoice.cancelled"
+	TransactionFailed    Type = "transaction.failed"
+	TransactionOrphaned  Type = "transaction.orphaned"
+	CardSaved            Type = "card.saved"
+	CardExpiringSoon     Type = "card.expiring_soon"
+	RefundProcessed      Type = "refund.processed"
+)
+
+// Types lists every event type that can be published.
+func Types() []Type {
+	return []Type{
+		InvoiceIssued,
+		InvoicePaid,
+		InvoiceExpired,
+		InvoiceReminder,
+		InvoiceUncollectible,
+		InvoiceCancelled,
+		TransactionFailed,
+		TransactionOrphaned,
+		CardSaved,
+		CardExpiringSoon,
+		RefundProcessed,
+	}
+}
+
+// Event carries a snapshot of the records involved at the time it was published.
+type Event struct {
+	ID          uuid.UUID           `json:"id"`
+	Type        Type                `json:"type"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Customer    *models.Customer    `json:"customer,omitempty"`
+	Invoice     *models.Invoice     `json:"invoice,omitempty"`
+	Transaction *models.Transaction `json:"transaction,omitempty"`
+	Card        *models.Card        `json:"card,omitempty"`
+	NextAttempt time.Time           `json:"next_attempt,omitempty"`
+}
+
+func (e Event) snapshot() Event {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	if e.Customer != nil {
+		e.Customer = e.Customer.Clone()
+	}
+	if e.Invoice != nil {
+		e.Invoice = e.Invoice.Clone()
+	}
+	if e.Transaction != nil {
+		e.Transaction = e.Transaction.Clone()
+	}
+	if e.Card != nil {
+		e.Card = e.Card.Clone()
+	}
+	return e
+}
+
+type Handler func(ctx context.Context, e Event) error
+
+type subscription struct {
+	id      int
+	handler Handler
+	async   bool
+}
+
+// Bus is an in-process publisher of payment lifecycle events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[Type][]subscription
+	next int
+	wg   sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[Type][]subscription),
+	}
+}
+
+// Subscribe runs h inline with Publish; its error is returned to the publisher.
+func (b *Bus) Subscribe(t Type, h Handler) (unsubscribe func()) {
+	return b.subscribe(t, h, false)
+}
+
+// SubscribeAsync runs h on its own goroutine; errors are logged.
+func (b *Bus) SubscribeAsync(t Type, h Handler) (unsubscribe func()) {
+	return b.subscribe(t, h, true)
+}
+
+func (b *Bus) subscribe(t Type, h Handler, async bool) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	id := b.next
+	b.subs[t] = append(b.subs[t], subscription{id: id, handler: h, async: async})
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.subs[t]
+		for i, s := range subs {
+			if s.id == id {
+				b.subs[t] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers e to every subscriber of its type and of All. Synchronous
+// subscribers run in subscription order and their errors are joined.
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	if b == nil {
+		return nil
+	}
+	e = e.snapshot()
+
+	b.mu.RLock()
+	subs := make([]subscription, 0, len(b.subs[e.Type])+len(b.subs[All]))
+	subs = append(subs, b.subs[e.Type]...)
+	subs = append(subs, b.subs[All]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, s := range subs {
+		if s.async {
+			b.wg.Add(1)
+			go func(h Handler) {
+				defer b.wg.Done()
+				if err := h(context.WithoutCancel(ctx), e.snapshot()); err != nil {
+					log.Printf("events: %s %s: %v", e.Type, e.ID, err)
+				}
+			}(s.handler)
+			continue
+		}
+		if err := s.handler(ctx, e.snapshot()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Wait blocks until in-flight asynchronous handlers return.
+func (b *Bus) Wait() {
+	b.wg.Wait()
+}
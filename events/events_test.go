@@ -0,0 +1,44 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+func TestSnapshotSharesNothingWithThePublisher(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	customer := &models.Customer{ID: uuid.New(), Metadata: map[string]string{"plan": "basic"}}
+	invoice := &models.Invoice{
+		ID:        uuid.New(),
+		LineItems: []*models.LineItem{{Description: "seat", Quantity: 1, UnitAmount: 100}},
+		History:   []*models.InvoiceTransition{{From: models.InvDraft, To: models.InvIssued, At: at}},
+	}
+	trx := models.NewTransaction(invoice.ID, "ref", at)
+	invoice.Transactions = []*models.Transaction{trx}
+
+	e := Event{Type: InvoicePaid, Customer: customer, Invoice: invoice, Transaction: trx}.snapshot()
+
+	customer.Metadata["plan"] = "pro"
+	invoice.LineItems[0].Quantity = 5
+	invoice.History[0].To = models.InvCancelled
+	trx.History[0].Status = models.TrxSuccess
+
+	if e.Customer.Metadata["plan"] != "basic" {
+		t.Error("customer metadata changed under the event")
+	}
+	if e.Invoice.LineItems[0].Quantity != 1 {
+		t.Error("line items changed under the event")
+	}
+	if e.Invoice.History[0].To != models.InvIssued {
+		t.Error("invoice history changed under the event")
+	}
+	if e.Transaction.History[0].Status != models.TrxPending {
+		t.Error("transaction history changed under the event")
+	}
+	if e.Invoice.Transactions[0].History[0].Status != models.TrxPending {
+		t.Error("the invoice's transactions changed under the event")
+	}
+}
@@ -0,0 +1,155 @@
+package cardexpiry
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// Expiry warns customers ahead of their saved cards expiring and deactivates
+// the cards once they have, so charges stop being attempted on them.
+type Expiry struct {
+	interval time.Duration
+	notice   time.Duration
+	batch    int
+}
+
+// New checks cards every interval and publishes CardExpiringSoon notice ahead
+// of a card's expiry.
+func New(interval, notice time.Duration) *Expiry {
+	if notice <= 0 {
+		notice = time.Hour * 24 * 30
+	}
+	return &Expiry{
+		interval: interval,
+		notice:   notice,
+		batch:    100,
+	}
+}
+
+// Billing runs the job as a background module.
+func (e *Expiry) Billing() *billing.Billing {
+	return (&billing.Billing{Name: "card_expiry"}).Every(e.interval, e.RunOnce)
+}
+
+// RunOnce deactivates the active cards that have expired, then warns about
+// those expiring within the notice that haven't been warned yet. Both are
+// queried by expiry month, a batch at a time.
+func (e *Expiry) RunOnce(ctx context.Context, bctx *billing.BillingContext) error {
+	now := bctx.Clock.Now()
+	expired := expiringBefore(now)
+	if err := e.each(ctx, bctx, expired, func(card *models.Card) error {
+		return e.deactivate(ctx, bctx, card.CustomerID, card.ID)
+	}); err != nil {
+		return err
+	}
+	expiring := expiringBefore(now.Add(e.notice))
+	expiring["expiry_notified"] = false
+	return e.each(ctx, bctx, expiring, func(card *models.Card) error {
+		if !now.Before(card.ExpiresAt()) {
+			// its deactivation failed above, it is retried on the next pass
+			return nil
+		}
+		return e.warn(ctx, bctx, card)
+	})
+}
+
+// expiringBefore matches the active cards that can't be charged any more at
+// t. A card expires at the start of the month after its expiry month, so
+// those are the cards whose expiry month is before t's.
+func expiringBefore(t time.Time) billing.Filter {
+	return billing.Filter{"deactivated_at": time.Time{}}.
+		Where("exp_year", "$gt", 0).
+		Or(
+			billing.Filter{}.Where("exp_year", "$lt", t.Year()),
+			billing.Filter{"exp_year": t.Year()}.Where("exp_month", "$lt", int(t.Month())),
+		)
+}
+
+// each calls fn on every card filter matches, a batch at a time by id. A
+// failing card is logged and skipped.
+func (e *Expiry) each(ctx context.Context, bctx *billing.BillingContext, filter billing.Filter, fn func(card *models.Card) error) error {
+	var last uuid.UUID
+	for {
+		page := maps.Clone(filter)
+		if last != uuid.Nil {
+			page.Where("id", "$gt", last)
+		}
+		opts := append(page.Options(), billing.Sort("id", false), database.WithLimit(int64(e.batch)))
+		cards, err := bctx.Card.Query(opts...).All()
+		if err != nil {
+			return err
+		}
+		for _, card := range cards {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(card); err != nil {
+				log.Printf("card_expiry: card %s: %v", card.ID, err)
+			}
+		}
+		if len(cards) < e.batch {
+			return nil
+		}
+		last = cards[len(cards)-1].ID
+	}
+}
+
+func (e *Expiry) warn(ctx context.Context, bctx *billing.BillingContext, card *models.Card) error {
+	customer, err := bctx.CustomerOf(card.CustomerID)
+	if err != nil {
+		return err
+	}
+	card.ExpiryNotified = true
+	if err := billing.Update(bctx.Card, card); err != nil {
+		return err
+	}
+	bctx.Publish(ctx, events.Event{Type: events.CardExpiringSoon, Customer: customer, Card: card})
+	return nil
+}
+
+// deactivate marks the card inactive. A default card hands over to the
+// customer's most recently used usable card, and HasCard is cleared once
+// none is left.
+func (e *Expiry) deactivate(ctx context.Context, bctx *billing.BillingContext, customer_id, card_id uuid.UUID) error {
+	now := bctx.Clock.Now()
+	cards, err := bctx.CustomerCards(customer_id)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(cards, func(c *models.Card) bool { return c.ID == card_id })
+	if idx < 0 {
+		return nil
+	}
+	card := cards[idx]
+	rest := slices.DeleteFunc(cards, func(c *models.Card) bool { return c.ID == card_id || !c.Usable(now) })
+
+	unit := bctx.Unit(ctx)
+	was_default := card.Default
+	card.DeactivatedAt = now
+	card.Default = false
+	billing.UpdateIn(unit, bctx.Card, card)
+	if was_default && len(rest) > 0 && !slices.ContainsFunc(rest, func(c *models.Card) bool { return c.Default }) {
+		rest[0].Default = true
+		billing.UpdateIn(unit, bctx.Card, rest[0])
+	}
+	if len(rest) == 0 {
+		customer, err := bctx.CustomerOf(customer_id)
+		if err != nil {
+			return err
+		}
+		if customer.HasCard {
+			customer.HasCard = false
+			billing.UpdateIn(unit, bctx.Customer, customer)
+		}
+	}
+	return unit.Commit()
+}
@@ -0,0 +1,289 @@
+package checkout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/payments/processors"
+)
+
+// NewCheckout serves the hosted invoice page. Every payment it starts asks the
+// processor to send the customer back to <base_url>/checkout/return.
+func NewCheckout(links *Links, branding documents.Branding) *billing.Billing {
+	branding = branding.WithDefaults()
+	fail := func(w http.ResponseWriter, status int, message string) {
+		v := newView(branding, nil)
+		v.State = "failed"
+		v.Message = message
+		v.render(w, status)
+	}
+
+	return &billing.Billing{
+		Name: "checkout",
+		Init: func(r chi.Router, ctx *billing.BillingContext) {
+			// the processor sends the customer back here with the transaction reference
+			r.Get("/return", func(w http.ResponseWriter, r *http.Request) {
+				reference := r.URL.Query().Get("reference")
+				if reference == "" {
+					reference = r.URL.Query().Get("trxref")
+				}
+				trx, err := ctx.Transactions.Query(database.WithFilter("reference", reference)).First()
+				if err != nil {
+					fail(w, http.StatusNotFound, "We couldn't find that payment.")
+					return
+				}
+				held, release, err := ctx.LockInvoice(r.Context(), trx.InvoiceID)
+				if err != nil {
+					// someone else is settling it, the page shows the outcome
+					http.Redirect(w, r, links.URL(trx.InvoiceID, ctx.Clock.Now()), http.StatusSeeOther)
+					return
+				}
+				defer release()
+				r = r.WithContext(held)
+				if trx, err = ctx.Transactions.Query(database.WithFilter("id", trx.ID)).First(); err != nil {
+					fail(w, http.StatusNotFound, "We couldn't find that payment.")
+					return
+				}
+				invoice, err := ctx.Invoice.Query(database.WithFilter("id", trx.InvoiceID)).First()
+				if err != nil {
+					fail(w, http.StatusNotFound, "We couldn't find that invoice.")
+					return
+				}
+				if trx.Status == models.TrxPending {
+					res, err := ctx.Processor.Verify(r.Context(), trx.Reference)
+					if err != nil {
+						fail(w, http.StatusBadGateway, "We couldn't confirm your payment, please refresh in a moment.")
+						return
+					}
+					customer, err := ctx.CustomerOf(invoice.CustomerID)
+					if err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't record your payment, please refresh in a moment.")
+						return
+					}
+					if err := ctx.Settle(r.Context(), customer, invoice, trx, res); err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't record your payment, please refresh in a moment.")
+						return
+					}
+				}
+				http.Redirect(w, r, links.URL(invoice.ID, ctx.Clock.Now()), http.StatusSeeOther)
+			})
+			r.Route("/{invoice_id}", func(r chi.Router) {
+				r.Use(func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						id, err := uuid.Parse(chi.URLParam(r, "invoice_id"))
+						if err != nil {
+							fail(w, http.StatusNotFound, "This payment link is not valid.")
+							return
+						}
+						if err := links.Verify(id, r.URL.Query(), ctx.Clock.Now()); err != nil {
+							if errors.Is(err, ErrExpiredLink) {
+								fail(w, http.StatusGone, "This payment link has expired, please ask for a new one.")
+								return
+							}
+							fail(w, http.StatusForbidden, "This payment link is not valid.")
+							return
+						}
+						next.ServeHTTP(w, r)
+					})
+				})
+				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+					invoice, err := ctx.Invoice.Query(database.WithFilter("id", uuid.MustParse(r.PathValue("invoice_id")))).First()
+					if err != nil {
+						fail(w, http.StatusNotFound, "We couldn't find that invoice.")
+						return
+					}
+					if invoice, err = lockAndExpire(r, ctx, invoice); err != nil {
+						fail(w, http.StatusInternalServerError, err.Error())
+						return
+					}
+
+					v := newView(branding, invoice)
+					status := invoice.Status
+					if overdue(ctx, invoice) {
+						// someone else holds the lease, the invoice is closed all the same
+						status = models.InvExpired
+					}
+					switch status {
+					case models.InvPaid:
+						v.State = "paid"
+						v.Message = "Payment received, thank you!"
+					case models.InvIssued:
+						v.PayURL = links.base_url + "/checkout/" + invoice.ID.String() + "/pay?" + r.URL.RawQuery
+						if trx := latest(ctx, invoice); trx != nil {
+							switch trx.Status {
+							case models.TrxFailed, models.TrxAbandonned:
+								v.State = "failed"
+								v.Message = "Your last payment attempt didn't go through, please try again."
+							case models.TrxPending:
+								v.Message = "If you have just paid, we're still confirming it."
+							}
+						}
+					default:
+						v.State = "failed"
+						v.Message = "This invoice can no longer be paid."
+					}
+					v.render(w, http.StatusOK)
+				})
+				r.Post("/pay", func(w http.ResponseWriter, r *http.Request) {
+					id := uuid.MustParse(r.PathValue("invoice_id"))
+					held, release, err := ctx.LockInvoice(r.Context(), id)
+					if errors.Is(err, lease.ErrHeld) {
+						fail(w, http.StatusConflict, "This invoice is already being paid, please wait a moment and refresh.")
+						return
+					}
+					if err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+						return
+					}
+					defer release()
+					r = r.WithContext(held)
+					invoice, err := ctx.Invoice.Query(database.WithFilter("id", id)).First()
+					if err != nil {
+						fail(w, http.StatusNotFound, "We couldn't find that invoice.")
+						return
+					}
+					customer, err := ctx.Customer.Query(database.WithFilter("id", invoice.CustomerID)).First()
+					if err != nil {
+						fail(w, http.StatusNotFound, "We couldn't find your account.")
+						return
+					}
+					if err := expire(r.Context(), ctx, customer, invoice); err != nil {
+						fail(w, http.StatusInternalServerError, err.Error())
+						return
+					}
+					back := links.URL(invoice.ID, ctx.Clock.Now())
+					if invoice.Status != models.InvIssued {
+						http.Redirect(w, r, back, http.StatusSeeOther)
+						return
+					}
+
+					// a payment started earlier may have completed in the meantime, one
+					// that is still open is resumed rather than started a second time
+					if trx := latest(ctx, invoice); trx != nil && trx.Status == models.TrxPending {
+						res, err := ctx.Processor.Verify(r.Context(), trx.Reference)
+						if err != nil {
+							res = processors.Pending
+						} else if err := ctx.Settle(r.Context(), customer, invoice, trx, res); err != nil {
+							fail(w, http.StatusInternalServerError, "We couldn't record your payment, please try again.")
+							return
+						}
+						switch res {
+						case processors.Success:
+							http.Redirect(w, r, back, http.StatusSeeOther)
+							return
+						case processors.Failed, processors.Abandoned, processors.Reversed:
+						default:
+							if trx.AuthorizationURL == "" {
+								fail(w, http.StatusConflict, "A payment is already in progress, please wait a moment and refresh.")
+								return
+							}
+							http.Redirect(w, r, trx.AuthorizationURL, http.StatusSeeOther)
+							return
+						}
+					}
+					if invoice.AttemptsExhausted() {
+						fail(w, http.StatusBadRequest, "This invoice has reached its limit of payment attempts.")
+						return
+					}
+
+					if err := ctx.BeforeCharge(r.Context(), invoice, customer); err != nil {
+						fail(w, http.StatusBadRequest, err.Error())
+						return
+					}
+
+					trx := models.NewTransaction(invoice.ID, ctx.NewReference(), ctx.Clock.Now())
+					if err := ctx.Transactions.Save(*trx); err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+						return
+					}
+					invoice.AttemptCount += 1
+					invoice.LastAttempt = ctx.Clock.Now()
+					if err := billing.Update(ctx.Invoice, invoice); err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+						return
+					}
+					auth_url, err := ctx.Processor.Init(r.Context(), customer.Email, invoice.Amount, trx.Reference, links.ReturnURL())
+					if err != nil {
+						// nothing was opened with the processor, the attempt can't be resumed
+						trx.SetStatus(models.TrxFailed, ctx.Clock.Now())
+						if err := billing.Update(ctx.Transactions, trx); err != nil {
+							fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+							return
+						}
+						fail(w, http.StatusBadGateway, "We couldn't reach the payment provider, please try again.")
+						return
+					}
+					trx.AuthorizationURL = auth_url
+					if err := billing.Update(ctx.Transactions, trx); err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+						return
+					}
+					http.Redirect(w, r, auth_url, http.StatusSeeOther)
+				})
+			})
+		},
+	}
+}
+
+// overdue reports whether an issued invoice is past its deadline, it can't be
+// paid any more.
+func overdue(ctx *billing.BillingContext, invoice *models.Invoice) bool {
+	return invoice.Status == models.InvIssued && ctx.Clock.Now().After(invoice.ExpiresAt)
+}
+
+// expire closes an overdue invoice. The caller holds the invoice's lease.
+func expire(c context.Context, ctx *billing.BillingContext, customer *models.Customer, invoice *models.Invoice) error {
+	if !overdue(ctx, invoice) {
+		return nil
+	}
+	if err := invoice.Transition(models.InvExpired, "system", "invoice expired", ctx.Clock.Now()); err != nil {
+		return err
+	}
+	if err := billing.Update(ctx.Invoice, invoice); err != nil {
+		return err
+	}
+	ctx.Publish(c, events.Event{Type: events.InvoiceExpired, Customer: customer, Invoice: invoice})
+	return nil
+}
+
+// lockAndExpire expires an overdue invoice from a page that doesn't otherwise
+// change it, taking the invoice's lease first. While someone else holds it,
+// the invoice is left to them and returned as it is.
+func lockAndExpire(r *http.Request, ctx *billing.BillingContext, invoice *models.Invoice) (*models.Invoice, error) {
+	if !overdue(ctx, invoice) {
+		return invoice, nil
+	}
+	held, release, err := ctx.LockInvoice(r.Context(), invoice.ID)
+	if errors.Is(err, lease.ErrHeld) {
+		return invoice, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if invoice, err = ctx.Invoice.Query(database.WithFilter("id", invoice.ID)).First(); err != nil {
+		return nil, err
+	}
+	customer, err := ctx.CustomerOf(invoice.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	return invoice, expire(held, ctx, customer, invoice)
+}
+
+func latest(ctx *billing.BillingContext, invoice *models.Invoice) *models.Transaction {
+	trx, err := ctx.LatestTransaction(invoice.ID)
+	if err != nil {
+		return nil
+	}
+	return trx
+}
@@ -0,0 +1,82 @@
+package checkout
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidLink = errors.New("checkout: invalid link")
+	ErrExpiredLink = errors.New("checkout: link has expired")
+)
+
+// Links signs and verifies checkout URLs, so only links handed out by the
+// merchant open an invoice's page, and only until they expire.
+type Links struct {
+	secret   []byte
+	base_url string
+	ttl      time.Duration
+}
+
+func NewLinks(secret, base_url string, ttl time.Duration) *Links {
+	return &Links{
+		secret:   []byte(secret),
+		base_url: strings.TrimSuffix(base_url, "/"),
+		ttl:      ttl,
+	}
+}
+
+func (l *Links) sign(id uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(id.String()))
+	mac.Write([]byte("."))
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Query returns the signed query string for an invoice's checkout page.
+func (l *Links) Query(id uuid.UUID, now time.Time) url.Values {
+	expires := strconv.FormatInt(now.Add(l.ttl).Unix(), 10)
+	return url.Values{
+		"expires":   {expires},
+		"signature": {l.sign(id, expires)},
+	}
+}
+
+// ReturnURL is where the processor sends customers back to once they have paid.
+func (l *Links) ReturnURL() string {
+	return l.base_url + "/checkout/return"
+}
+
+// URL returns the absolute checkout page URL for an invoice.
+func (l *Links) URL(id uuid.UUID, now time.Time) string {
+	return l.base_url + "/checkout/" + id.String() + "?" + l.Query(id, now).Encode()
+}
+
+func (l *Links) Verify(id uuid.UUID, query url.Values, now time.Time) error {
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || expires == "" {
+		return ErrInvalidLink
+	}
+	expected, _ := hex.DecodeString(l.sign(id, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidLink
+	}
+	at, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidLink
+	}
+	if now.Unix() > at {
+		return ErrExpiredLink
+	}
+	return nil
+}
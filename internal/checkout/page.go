@@ -0,0 +1,111 @@
+package checkout
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var page = template.Must(template.New("checkout").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Merchant}}{{if .Number}} - Invoice {{.Number}}{{end}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; background: #f4f5f7; margin: 0; color: #212529; }
+header { background: {{.Primary}}; color: #fff; padding: 20px 24px; font-size: 1.25em; font-weight: bold; }
+main { max-width: 560px; margin: 24px auto; background: #fff; padding: 24px; border-radius: 8px; }
+table { width: 100%; border-collapse: collapse; margin: 16px 0; }
+th, td { padding: 8px 0; border-bottom: 1px solid {{.Accent}}; text-align: left; }
+.r { text-align: right; }
+.total td { font-weight: bold; font-size: 1.15em; border-bottom: 0; }
+.state { padding: 12px 16px; border-radius: 4px; margin-bottom: 16px; background: {{.Accent}}; }
+.paid { background: #e6f4ea; }
+.failed { background: #fdecea; }
+button { background: {{.Primary}}; color: #fff; border: 0; padding: 14px; border-radius: 4px; font-size: 1em; width: 100%; cursor: pointer; }
+</style>
+</head>
+<body>
+<header>{{.Merchant}}</header>
+<main>
+{{if .Message}}<div class="state {{.State}}">{{.Message}}</div>{{end}}
+{{if .Number}}<p>Invoice <strong>{{.Number}}</strong>{{if .Due}} &middot; due {{.Due}}{{end}}</p>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Items}}
+<table>
+<tr><th>Item</th><th class="r">Qty</th><th class="r">Amount</th></tr>
+{{range .Items}}<tr><td>{{.Description}}</td><td class="r">{{.Quantity}}</td><td class="r">{{.Amount}}</td></tr>
+{{end}}
+{{if .Tax}}<tr><td>Tax</td><td></td><td class="r">{{.Tax}}</td></tr>{{end}}
+<tr class="total"><td>Total</td><td></td><td class="r">{{.Total}}</td></tr>
+</table>
+{{end}}
+{{if .PayURL}}
+<form method="post" action="{{.PayURL}}">
+<button type="submit">Pay {{.Total}}</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type item struct {
+	Description string
+	Quantity    int64
+	Amount      string
+}
+
+type view struct {
+	Merchant    string
+	Primary     string
+	Accent      string
+	State       string
+	Message     string
+	Number      string
+	Description string
+	Due         string
+	Items       []item
+	Tax         string
+	Total       string
+	PayURL      string
+}
+
+func newView(branding documents.Branding, invoice *models.Invoice) *view {
+	v := &view{
+		Merchant: branding.Name,
+		Primary:  branding.PrimaryColor.Hex(),
+		Accent:   branding.AccentColor.Hex(),
+	}
+	if invoice == nil {
+		return v
+	}
+	if invoice.Numbered() {
+		v.Number = invoice.Number
+	}
+	v.Description = invoice.Description
+	v.Total = branding.Money(invoice.Amount)
+	if !invoice.ExpiresAt.IsZero() {
+		v.Due = invoice.ExpiresAt.Format("02 Jan 2006")
+	}
+	if invoice.Tax > 0 {
+		v.Tax = branding.Money(invoice.Tax)
+	}
+	for _, li := range invoice.LineItems {
+		v.Items = append(v.Items, item{Description: li.Description, Quantity: li.Quantity, Amount: branding.Money(li.Total())})
+	}
+	if len(v.Items) == 0 {
+		v.Items = []item{{Description: invoice.Description, Quantity: 1, Amount: branding.Money(invoice.Amount - invoice.Tax)}}
+	}
+	return v
+}
+
+func (v *view) render(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page.Execute(w, v)
+}
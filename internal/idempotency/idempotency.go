@@ -0,0 +1,239 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/utilities"
+)
+
+var (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// replayed along with the status code and body
+	stored_headers = []string{"Content-Type", "Location"}
+
+	key_limit  = 255
+	body_limit = int64(1 << 20)
+)
+
+var errTaken = errors.New("idempotency: key taken by another request")
+
+type Option func(*Keys)
+
+// Keys stores responses to POST and PATCH requests by their Idempotency-Key.
+type Keys struct {
+	keys      *billing.Handle[models.IdempotencyKey]
+	clock     clock.Clock
+	retention time.Duration
+	// a request still in progress after this long is assumed to have died
+	lock_timeout time.Duration
+	interval     time.Duration
+}
+
+// WithRetention sets how long a key and its response are kept, 24 hours by default.
+func WithRetention(retention time.Duration) Option {
+	return func(k *Keys) {
+		k.retention = retention
+	}
+}
+
+// WithInterval sets how often expired keys are purged.
+func WithInterval(interval time.Duration) Option {
+	return func(k *Keys) {
+		k.interval = interval
+	}
+}
+
+// New declares the idempotency_keys collection, bound by the module Billing returns.
+func New(c clock.Clock, opts ...Option) *Keys {
+	cfg := &Keys{
+		keys:         billing.Declare[models.IdempotencyKey]("idempotency_keys"),
+		clock:        c,
+		retention:    time.Hour * 24,
+		lock_timeout: time.Minute * 5,
+		interval:     time.Hour,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Billing purges expired keys in the background.
+func (k *Keys) Billing() *billing.Billing {
+	return (&billing.Billing{
+		Name:        "idempotency",
+		Collections: []billing.Collection{k.keys},
+	}).Every(k.interval, func(ctx context.Context, _ *billing.BillingContext) error {
+		return k.Purge(ctx)
+	})
+}
+
+// Purge deletes every key past its retention.
+func (k *Keys) Purge(ctx context.Context) error {
+	expired := billing.Filter{}.Where("expires_at", "$lt", k.clock.Now())
+	return k.keys.Model().Query(expired.Options()...).DeleteMany()
+}
+
+// Middleware replays the stored response when a POST or PATCH repeats an
+// Idempotency-Key. Reusing a key for a different request is refused with 422
+// until the key expires, even when the first request was abandoned, and a
+// retry arriving while the first request is still running gets 409.
+// Server errors are not stored, the key is freed so a retry runs again.
+// Requests without the header pass straight through.
+func (k *Keys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if id == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(id) > key_limit {
+			fail(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, body_limit))
+		if err != nil {
+			fail(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+
+		now := k.clock.Now()
+		key := &models.IdempotencyKey{
+			ID:          id,
+			Method:      r.Method,
+			Path:        r.URL.RequestURI(),
+			RequestHash: hex.EncodeToString(hash[:]),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(k.retention),
+			Token:       uuid.NewString(),
+		}
+		if stored, err := k.keys.Model().Query(database.WithFilter("id", id)).First(); err == nil {
+			expired := stored.ExpiresAt.Before(now)
+			switch {
+			case !expired && stored.RequestHash != key.RequestHash:
+				fail(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				return
+			case expired, !stored.Completed && stored.CreatedAt.Add(k.lock_timeout).Before(now):
+				// expired, or left behind by the same request that never finished
+				if err := k.takeover(stored, key); errors.Is(err, errTaken) {
+					fail(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+					return
+				} else if err != nil {
+					fail(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+			case !stored.Completed:
+				fail(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+				return
+			default:
+				replay(w, stored)
+				return
+			}
+		} else if err := k.keys.Model().Save(*key); err != nil {
+			// the unique id lost a race against a concurrent retry
+			fail(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		held := k.keys.Model().Query(database.WithFilter("id", id), database.WithFilter("token", key.Token))
+		if rec.status >= http.StatusInternalServerError {
+			if err := held.Delete(); err != nil {
+				log.Printf("idempotency: releasing %s: %v", id, err)
+			}
+			return
+		}
+		key.Completed = true
+		key.StatusCode = rec.status
+		if key.StatusCode == 0 {
+			key.StatusCode = http.StatusOK
+		}
+		key.Body = rec.body.Bytes()
+		key.Header = make(map[string]string)
+		for _, h := range stored_headers {
+			if v := w.Header().Get(h); v != "" {
+				key.Header[h] = v
+			}
+		}
+		if err := held.Update(*key); err != nil {
+			// the response is already sent, retries get a 409 until the lock times out
+			log.Printf("idempotency: storing response for %s: %v", id, err)
+		}
+	})
+}
+
+// takeover claims a key found expired or abandoned, only while it is still
+// the stored copy, so two retries racing for it can't both run.
+func (k *Keys) takeover(stored, key *models.IdempotencyKey) error {
+	err := k.keys.Model().Query(
+		database.WithFilter("id", stored.ID),
+		database.WithFilter("created_at", stored.CreatedAt),
+		database.WithFilter("completed", stored.Completed),
+	).Update(*key)
+	if err != nil {
+		return err
+	}
+	claimed, err := k.keys.Model().Query(database.WithFilter("id", key.ID)).First()
+	if err != nil {
+		return err
+	}
+	if claimed.Token != key.Token {
+		return errTaken
+	}
+	return nil
+}
+
+func replay(w http.ResponseWriter, key *models.IdempotencyKey) {
+	for h, v := range key.Header {
+		w.Header().Set(h, v)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(key.StatusCode)
+	w.Write(key.Body)
+}
+
+func fail(w http.ResponseWriter, status int, message string) {
+	utilities.JSON(w).SetStatus(utilities.ResponseFail).
+		SetStatusCode(status).SetMessage(message).Send()
+}
+
+// recorder passes the response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
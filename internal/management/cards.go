@@ -0,0 +1,43 @@
+package management
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/utilities"
+)
+
+// customerCard loads the customer in the path, their cards and the one named
+// by card_id. It answers 404 when either is missing and reports whether the
+// caller may go on.
+func customerCard(w http.ResponseWriter, r *http.Request, ctx *billing.BillingContext) (*models.Customer, []*models.Card, *models.Card, bool) {
+	customer_id, err := uuid.Parse(r.PathValue("customer_id"))
+	if err != nil {
+		utilities.JSON(w).SetStatus(utilities.ResponseFail).
+			SetStatusCode(http.StatusNotFound).SetMessage("Customer not found").Send()
+		return nil, nil, nil, false
+	}
+	customer, err := ctx.Customer.Query(database.WithFilter("id", customer_id)).First()
+	if err != nil {
+		utilities.JSON(w).SetStatus(utilities.ResponseFail).
+			SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+		return nil, nil, nil, false
+	}
+	cards, err := ctx.CustomerCards(customer.ID)
+	if err != nil {
+		utilities.JSON(w).SetStatus(utilities.ResponseError).
+			SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+		return nil, nil, nil, false
+	}
+	for _, card := range cards {
+		if card.ID.String() == r.PathValue("card_id") {
+			return customer, cards, card, true
+		}
+	}
+	utilities.JSON(w).SetStatus(utilities.ResponseFail).
+		SetStatusCode(http.StatusNotFound).SetMessage("Card not found").Send()
+	return nil, nil, nil, false
+}
@@ -0,0 +1,134 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/processors"
+)
+
+var (
+	phone_pattern    = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+	country_pattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	currency_pattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+	max_metadata_keys  = 50
+	max_metadata_key   = 40
+	max_metadata_value = 500
+)
+
+// customerBody is the editable part of a customer. Nil fields are left as
+// they are, a null address is cleared, and metadata is merged key by key
+// with empty values removing their key.
+type customerBody struct {
+	FirstName *string           `json:"first_name"`
+	LastName  *string           `json:"last_name"`
+	Email     *string           `json:"email"`
+	Phone     *string           `json:"phone"`
+	Country   *string           `json:"country"`
+	Currency  *string           `json:"currency"`
+	Address   json.RawMessage   `json:"address"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+func (b *customerBody) apply(customer *models.Customer) error {
+	if b.FirstName != nil {
+		customer.FirstName = strings.TrimSpace(*b.FirstName)
+	}
+	if b.LastName != nil {
+		customer.LastName = strings.TrimSpace(*b.LastName)
+	}
+	if b.Email != nil {
+		email := strings.TrimSpace(*b.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			return errors.New("email is not a valid address")
+		}
+		customer.Email = email
+	}
+	if b.Phone != nil {
+		phone := strings.Join(strings.Fields(*b.Phone), "")
+		if phone != "" && !phone_pattern.MatchString(phone) {
+			return errors.New("phone must be 7 to 15 digits, optionally starting with +")
+		}
+		customer.Phone = phone
+	}
+	if b.Country != nil {
+		country := strings.ToUpper(strings.TrimSpace(*b.Country))
+		if country != "" && !country_pattern.MatchString(country) {
+			return errors.New("country must be a two letter ISO 3166 code")
+		}
+		customer.Country = country
+	}
+	if b.Currency != nil {
+		currency := strings.ToUpper(strings.TrimSpace(*b.Currency))
+		if currency != "" && !currency_pattern.MatchString(currency) {
+			return errors.New("currency must be a three letter ISO 4217 code")
+		}
+		customer.Currency = currency
+	}
+	if len(b.Address) > 0 {
+		var address *models.Address
+		if err := json.Unmarshal(b.Address, &address); err != nil {
+			return errors.New("address is not valid")
+		}
+		if address != nil {
+			address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+			if address.Country != "" && !country_pattern.MatchString(address.Country) {
+				return errors.New("address country must be a two letter ISO 3166 code")
+			}
+		}
+		customer.Address = address
+	}
+	if b.Metadata != nil {
+		metadata := maps.Clone(customer.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		for key, value := range b.Metadata {
+			if key == "" || len(key) > max_metadata_key {
+				return fmt.Errorf("metadata keys must be 1 to %d characters", max_metadata_key)
+			}
+			if len(value) > max_metadata_value {
+				return fmt.Errorf("metadata value of %q exceeds %d characters", key, max_metadata_value)
+			}
+			if value == "" {
+				delete(metadata, key)
+				continue
+			}
+			metadata[key] = value
+		}
+		if len(metadata) > max_metadata_keys {
+			return fmt.Errorf("a customer can have at most %d metadata keys", max_metadata_keys)
+		}
+		customer.Metadata = metadata
+	}
+	return nil
+}
+
+// changeEmail refuses an email change the customer's processor can't follow.
+func changeEmail(processor processors.Processor, customer *models.Customer, before string) error {
+	if customer.Email == before || customer.ProcessorID == "" {
+		return nil
+	}
+	if _, ok := processor.(processors.EmailUpdater); !ok {
+		return errors.New("email can't be changed once the customer is registered with the payment processor")
+	}
+	return nil
+}
+
+// processorCustomer is what the processor keeps of a customer.
+func processorCustomer(customer *models.Customer) *processors.Customer {
+	return &processors.Customer{
+		Email:     customer.Email,
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		Phone:     customer.Phone,
+		Metadata:  customer.Metadata,
+	}
+}
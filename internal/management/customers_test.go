@@ -0,0 +1,35 @@
+package management
+
+import (
+	"context"
+	"testing"
+
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/processors"
+)
+
+// updater syncs profiles but, like Paystack, not their email.
+type updater struct{ processors.Processor }
+
+func (updater) UpdateCustomer(context.Context, string, *processors.Customer) error { return nil }
+
+type emailUpdater struct{ updater }
+
+func (emailUpdater) UpdatesEmail() {}
+
+func TestChangeEmailNeedsAProcessorThatSyncsIt(t *testing.T) {
+	linked := &models.Customer{Email: "new@example.com", ProcessorID: "CUS_1"}
+	if err := changeEmail(updater{}, linked, "old@example.com"); err == nil {
+		t.Error("email of a linked customer changed on a processor that keeps the old one")
+	}
+	if err := changeEmail(emailUpdater{}, linked, "old@example.com"); err != nil {
+		t.Errorf("got %v from a processor that syncs email", err)
+	}
+	if err := changeEmail(updater{}, linked, "new@example.com"); err != nil {
+		t.Errorf("got %v for an unchanged email", err)
+	}
+	unlinked := &models.Customer{Email: "new@example.com"}
+	if err := changeEmail(updater{}, unlinked, "old@example.com"); err != nil {
+		t.Errorf("got %v for a customer the processor doesn't know", err)
+	}
+}
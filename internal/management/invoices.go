@@ -0,0 +1,212 @@
+package management
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/utilities"
+)
+
+var default_expiry = time.Hour * 24
+
+// draftBody is the editable part of a draft invoice. Nil fields are left as
+// they are. Amount is the total due, tax included, and is computed instead
+// when the invoice has line items.
+type draftBody struct {
+	Amount      *int64              `json:"amount"`
+	Description *string             `json:"description"`
+	LineItems   *[]*models.LineItem `json:"line_items"`
+	Tax         *int64              `json:"tax"`
+	DueDate     *time.Time          `json:"due_date"`
+}
+
+func (b *draftBody) apply(invoice *models.Invoice, now time.Time) error {
+	if b.Description != nil {
+		invoice.Description = *b.Description
+	}
+	if b.DueDate != nil {
+		if !b.DueDate.IsZero() && b.DueDate.Before(now) {
+			return errors.New("due date must be in the future")
+		}
+		invoice.DueDate = b.DueDate.UTC()
+	}
+	if b.LineItems != nil {
+		invoice.LineItems = *b.LineItems
+	}
+	if b.Tax != nil {
+		if *b.Tax < 0 {
+			return errors.New("tax cannot be negative")
+		}
+		invoice.Tax = *b.Tax
+	}
+	if b.Amount != nil {
+		if len(invoice.LineItems) > 0 {
+			return errors.New("amount is computed from line items")
+		}
+		if *b.Amount < 0 {
+			return errors.New("amount cannot be negative")
+		}
+		invoice.Amount = *b.Amount
+	}
+	if len(invoice.LineItems) > 0 {
+		var total int64
+		for _, item := range invoice.LineItems {
+			if item == nil || item.Quantity <= 0 || item.UnitAmount < 0 {
+				return errors.New("line items need a positive quantity and unit amount")
+			}
+			total += item.Total()
+		}
+		invoice.Amount = total + invoice.Tax
+	}
+	if invoice.Tax > invoice.Amount {
+		return errors.New("tax cannot exceed the invoice amount")
+	}
+	return nil
+}
+
+// finalize issues a draft invoice at now and starts its expiry clock. The
+// number is issued with the invoice's write, see billing.NumberIn.
+func finalize(invoice *models.Invoice, actor, reason string, now time.Time) error {
+	if invoice.Amount <= 0 {
+		return errors.New("invoice amount must be positive")
+	}
+	if err := invoice.Transition(models.InvIssued, actor, reason, now); err != nil {
+		return err
+	}
+	invoice.ExpiresAt = now.Add(default_expiry)
+	if !invoice.DueDate.IsZero() {
+		invoice.ExpiresAt = invoice.DueDate
+	}
+	return nil
+}
+
+// timelineEntry is one step in the life of an invoice: a status change of
+// the invoice itself or of one of its payment attempts.
+type timelineEntry struct {
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`
+	Status string    `json:"status"`
+	From   string    `json:"from,omitempty"`
+	Actor  string    `json:"actor,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	// Attempt numbers the invoice's transactions from 1, oldest first.
+	Attempt       int        `json:"attempt,omitempty"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	Reference     string     `json:"reference,omitempty"`
+}
+
+// timeline merges the invoice's status history with that of its
+// transactions, which must be sorted oldest first, into one ordered list.
+func timeline(invoice *models.Invoice, transactions []*models.Transaction) []*timelineEntry {
+	initial := invoice.Status
+	if len(invoice.History) > 0 {
+		initial = invoice.History[0].From
+	}
+	entries := []*timelineEntry{{At: invoice.CreatedAt, Kind: "invoice", Status: initial, Reason: "created"}}
+	for _, t := range invoice.History {
+		entries = append(entries, &timelineEntry{
+			At:     t.At,
+			Kind:   "invoice",
+			Status: t.To,
+			From:   t.From,
+			Actor:  t.Actor,
+			Reason: t.Reason,
+		})
+	}
+	for i, trx := range transactions {
+		history := trx.History
+		if len(history) == 0 {
+			// stored before transactions kept a history
+			history = []*models.TransactionStatus{{Status: trx.Status, At: trx.CreatedAt}}
+		}
+		from := ""
+		for _, h := range history {
+			entries = append(entries, &timelineEntry{
+				At:            h.At,
+				Kind:          "transaction",
+				Status:        h.Status,
+				From:          from,
+				Attempt:       i + 1,
+				TransactionID: &trx.ID,
+				Reference:     trx.Reference,
+			})
+			from = h.Status
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b *timelineEntry) int {
+		return a.At.Compare(b.At)
+	})
+	return entries
+}
+
+// locked holds the lease on {invoice_id} while a POST or PATCH runs, so
+// handlers see the invoice as no one else is changing it.
+func locked(ctx *billing.BillingContext) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := uuid.Parse(r.PathValue("invoice_id"))
+			if err != nil {
+				utilities.JSON(w).SetStatus(utilities.ResponseFail).
+					SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+				return
+			}
+			held, release, err := ctx.LockInvoice(r.Context(), id)
+			if errors.Is(err, lease.ErrHeld) {
+				utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+					SetMessage("Invoice is being processed by another request, please try again").Send()
+				return
+			}
+			if err != nil {
+				utilities.JSON(w).SetStatus(utilities.ResponseError).
+					SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+				return
+			}
+			defer release()
+			next.ServeHTTP(w, r.WithContext(held))
+		})
+	}
+}
+
+// lockAll holds the leases of every invoice, or of none when one of them is
+// taken. Each lease is taken under the previous one's context, so held is
+// cancelled when any of them is lost.
+func lockAll(c context.Context, ctx *billing.BillingContext, invoices []*models.Invoice) (held context.Context, release func(), err error) {
+	releases := make([]func(), 0, len(invoices))
+	release = func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	held = c
+	for _, invoice := range invoices {
+		h, r, err := ctx.LockInvoice(held, invoice.ID)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		held = h
+		releases = append(releases, r)
+	}
+	return held, release, nil
+}
+
+func sendPDF(w http.ResponseWriter, filename string, buf *bytes.Buffer) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,169 @@
+package management
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// memModel serves every query from memory and counts them. It ignores
+// filters, which is enough to count the queries a handler makes. Methods
+// beyond Save, Query and All are left to the embedded interfaces and are not
+// used by the list route.
+type memModel[T any] struct {
+	database.Model[T]
+	items   []*T
+	queries *int
+}
+
+type memQuery[T any] struct {
+	database.Query[T]
+	model *memModel[T]
+}
+
+func (m *memModel[T]) Save(doc T) error {
+	m.items = append(m.items, &doc)
+	return nil
+}
+
+func (m *memModel[T]) Query(...database.Options) database.Query[T] {
+	*m.queries++
+	return memQuery[T]{model: m}
+}
+
+func (q memQuery[T]) All() ([]*T, error) {
+	items := make([]*T, 0, len(q.model.items))
+	for _, item := range q.model.items {
+		doc := *item
+		items = append(items, &doc)
+	}
+	return items, nil
+}
+
+// listing builds the invoice list of one customer with per_invoice
+// transactions on each of its invoices.
+func listing(invoices, per_invoice int) (*billing.BillingContext, chi.Router, uuid.UUID, *int) {
+	queries := 0
+	invoice := &memModel[models.Invoice]{queries: &queries}
+	transactions := &memModel[models.Transaction]{queries: &queries}
+	customer := uuid.New()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range invoices {
+		id := uuid.New()
+		_ = invoice.Save(models.Invoice{ID: id, CustomerID: customer, Amount: 100, Status: models.InvIssued, CreatedAt: at.Add(time.Duration(i) * time.Minute)})
+		for j := range per_invoice {
+			_ = transactions.Save(*models.NewTransaction(id, fmt.Sprintf("ref-%d-%d", i, j), at.Add(time.Duration(j)*time.Second)))
+		}
+	}
+	ctx := &billing.BillingContext{Invoice: invoice, Transactions: transactions, Clock: clock.Real()}
+	r := chi.NewRouter()
+	NewManagement().Init(r, ctx)
+	return ctx, r, customer, &queries
+}
+
+func BenchmarkInvoiceList(b *testing.B) {
+	for _, invoices := range []int{10, 100} {
+		ctx, r, customer, queries := listing(invoices, 3)
+		list := fmt.Sprintf("/%s/invoices/?limit=%d", customer, invoices)
+
+		// the listing plus a transactions query per invoice, as clients
+		// loaded them before expand=transactions
+		b.Run(fmt.Sprintf("per_invoice/%d", invoices), func(b *testing.B) {
+			*queries = 0
+			for i := 0; i < b.N; i++ {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, list, nil))
+				for _, inv := range ctx.Invoice.(*memModel[models.Invoice]).items {
+					if _, err := ctx.InvoiceTransactions(inv.ID); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(*queries)/float64(b.N), "queries/op")
+		})
+
+		b.Run(fmt.Sprintf("expanded/%d", invoices), func(b *testing.B) {
+			*queries = 0
+			for i := 0; i < b.N; i++ {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, list+"&expand=transactions", nil))
+			}
+			b.ReportMetric(float64(*queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func TestInvoiceListExpandsTransactionsInOneQuery(t *testing.T) {
+	_, r, customer, queries := listing(20, 2)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s/invoices/?limit=20&expand=transactions", customer), nil))
+	if *queries != 2 {
+		t.Fatalf("got %d queries, want one for the invoices and one for their transactions", *queries)
+	}
+}
+
+func TestDraftComputesAmountFromLineItems(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{Status: models.InvDraft}
+	items := []*models.LineItem{{Description: "seat", Quantity: 3, UnitAmount: 1000}}
+	tax := int64(300)
+	if err := (&draftBody{LineItems: &items, Tax: &tax}).apply(invoice, now); err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Amount != 3300 {
+		t.Fatalf("got amount %d, want 3300", invoice.Amount)
+	}
+
+	amount := int64(500)
+	if err := (&draftBody{Amount: &amount}).apply(invoice, now); err == nil {
+		t.Error("amount set on an invoice with line items")
+	}
+	past := now.Add(-time.Hour)
+	if err := (&draftBody{DueDate: &past}).apply(invoice, now); err == nil {
+		t.Error("due date set in the past")
+	}
+	bare := &models.Invoice{Status: models.InvDraft, Amount: 100}
+	if err := (&draftBody{Tax: &tax}).apply(bare, now); err == nil {
+		t.Error("tax set above the amount")
+	}
+}
+
+func TestFinalizeIssuesTheDraft(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	invoice := &models.Invoice{Status: models.InvDraft, Amount: 1000}
+	if err := finalize(invoice, "admin", "ready", now); err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Status != models.InvIssued || !invoice.ExpiresAt.Equal(now.Add(default_expiry)) {
+		t.Fatalf("got %s expiring at %v", invoice.Status, invoice.ExpiresAt)
+	}
+	if last := invoice.History[len(invoice.History)-1]; last.From != models.InvDraft || last.Actor != "admin" {
+		t.Errorf("got %+v", last)
+	}
+
+	due := now.Add(7 * 24 * time.Hour)
+	dated := &models.Invoice{Status: models.InvDraft, Amount: 1000, DueDate: due}
+	if err := finalize(dated, "admin", "", now); err != nil || !dated.ExpiresAt.Equal(due) {
+		t.Fatalf("got %v expiring at %v, want the due date", err, dated.ExpiresAt)
+	}
+}
+
+func TestFinalizeRefusesEmptyOrIssuedInvoices(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	empty := &models.Invoice{Status: models.InvDraft}
+	if err := finalize(empty, "admin", "", now); err == nil || empty.Status != models.InvDraft {
+		t.Errorf("finalized an invoice of nothing: %v", err)
+	}
+	issued := &models.Invoice{Status: models.InvIssued, Amount: 1000}
+	if err := finalize(issued, "admin", "", now); !errors.Is(err, models.ErrInvalidTransition) {
+		t.Errorf("got %v, want ErrInvalidTransition", err)
+	}
+}
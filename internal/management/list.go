@@ -0,0 +1,255 @@
+package management
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var (
+	default_limit = 10
+	max_limit     = 100
+)
+
+// listPage is one page of a list. Pass NextCursor back as starting_after to
+// get the page after it.
+type listPage[T any] struct {
+	Data       []*T   `json:"data"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// listQuery holds the parameters shared by list routes:
+//
+//	limit           page size, 1 to 100, 10 by default
+//	starting_after  next_cursor of the previous page
+//	sort            a sort key, prefixed with - for descending order
+//	created_after   RFC 3339 time, inclusive
+//	created_before  RFC 3339 time, exclusive
+//	amount_min      inclusive, in minor units
+//	amount_max      inclusive, in minor units
+type listQuery struct {
+	limit          int
+	starting_after string
+	sort           string
+	created_after  time.Time
+	created_before time.Time
+	amount_min     *int64
+	amount_max     *int64
+}
+
+func parseList(r *http.Request, default_sort string) (*listQuery, error) {
+	values := r.URL.Query()
+	q := &listQuery{limit: default_limit, sort: default_sort, starting_after: values.Get("starting_after")}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > max_limit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", max_limit)
+		}
+		q.limit = limit
+	}
+	if v := values.Get("sort"); v != "" {
+		q.sort = v
+	}
+	for key, t := range map[string]*time.Time{"created_after": &q.created_after, "created_before": &q.created_before} {
+		if v := values.Get(key); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time", key)
+			}
+			*t = parsed
+		}
+	}
+	for key, amount := range map[string]**int64{"amount_min": &q.amount_min, "amount_max": &q.amount_max} {
+		if v := values.Get(key); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an amount in minor units", key)
+			}
+			*amount = &parsed
+		}
+	}
+	return q, nil
+}
+
+// expanded reports whether field was asked for with expand, which takes a
+// comma separated list and may be repeated.
+func expanded(r *http.Request, field string) bool {
+	for _, v := range r.URL.Query()["expand"] {
+		if slices.Contains(strings.Split(v, ","), field) {
+			return true
+		}
+	}
+	return false
+}
+
+// created restricts f to the created_after and created_before range.
+func (q *listQuery) created(f billing.Filter) billing.Filter {
+	if !q.created_after.IsZero() {
+		f.Where("created_at", "$gte", q.created_after)
+	}
+	if !q.created_before.IsZero() {
+		f.Where("created_at", "$lt", q.created_before)
+	}
+	return f
+}
+
+// amount restricts f to the amount_min and amount_max range.
+func (q *listQuery) amount(f billing.Filter) billing.Filter {
+	if q.amount_min != nil {
+		f.Where("amount", "$gte", *q.amount_min)
+	}
+	if q.amount_max != nil {
+		f.Where("amount", "$lte", *q.amount_max)
+	}
+	return f
+}
+
+// sortKey is a field a list can be ordered by. cursor writes an item's value
+// into a page cursor and parse reads it back as the stored type.
+type sortKey[T any] struct {
+	field  string
+	cursor func(*T) string
+	parse  func(string) (any, error)
+}
+
+func timeKey[T any](field string, get func(*T) time.Time) sortKey[T] {
+	return sortKey[T]{
+		field:  field,
+		cursor: func(item *T) string { return get(item).UTC().Format(time.RFC3339Nano) },
+		parse: func(v string) (any, error) {
+			return time.Parse(time.RFC3339Nano, v)
+		},
+	}
+}
+
+func intKey[T any](field string, get func(*T) int64) sortKey[T] {
+	return sortKey[T]{
+		field:  field,
+		cursor: func(item *T) string { return strconv.FormatInt(get(item), 10) },
+		parse: func(v string) (any, error) {
+			return strconv.ParseInt(v, 10, 64)
+		},
+	}
+}
+
+func stringKey[T any](field string, get func(*T) string) sortKey[T] {
+	return sortKey[T]{
+		field:  field,
+		cursor: get,
+		parse:  func(v string) (any, error) { return v, nil },
+	}
+}
+
+var customer_sort = map[string]sortKey[models.Customer]{
+	"created_at": timeKey("created_at", func(c *models.Customer) time.Time { return c.CreatedAt }),
+	"email":      stringKey("email", func(c *models.Customer) string { return c.Email }),
+	"last_name":  stringKey("last_name", func(c *models.Customer) string { return c.LastName }),
+}
+
+var invoice_sort = map[string]sortKey[models.Invoice]{
+	"created_at": timeKey("created_at", func(inv *models.Invoice) time.Time { return inv.CreatedAt }),
+	"amount":     intKey("amount", func(inv *models.Invoice) int64 { return inv.Amount }),
+	"due_date":   timeKey("due_date", func(inv *models.Invoice) time.Time { return inv.DueDate }),
+	"paid_at":    timeKey("paid_at", func(inv *models.Invoice) time.Time { return inv.PaidAt }),
+}
+
+var transaction_sort = map[string]sortKey[models.Transaction]{
+	"created_at": timeKey("created_at", func(trx *models.Transaction) time.Time { return trx.CreatedAt }),
+	"updated_at": timeKey("updated_at", func(trx *models.Transaction) time.Time { return trx.UpdatedAt }),
+}
+
+var errCursor = errors.New("starting_after is not a cursor from this list")
+
+// encodeCursor names the position after an item: the sort it was listed by,
+// its value for that sort and its ID.
+func encodeCursor(sort, value string, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sort + "\n" + value + "\n" + id.String()))
+}
+
+func decodeCursor(cursor, sort string) (value string, id uuid.UUID, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", uuid.Nil, errCursor
+	}
+	rest, ok := strings.CutPrefix(string(raw), sort+"\n")
+	i := strings.LastIndex(rest, "\n")
+	if !ok || i < 0 {
+		return "", uuid.Nil, errCursor
+	}
+	if id, err = uuid.Parse(rest[i+1:]); err != nil {
+		return "", uuid.Nil, errCursor
+	}
+	return rest[:i], id, nil
+}
+
+// after restricts f to the items following the cursor in the given order,
+// those with a later sort value or the same value and a later ID. It only
+// needs the cursor's values, so the item it came from may be gone.
+func after[T any](f billing.Filter, cursor, sort string, key sortKey[T], desc bool) (billing.Filter, error) {
+	raw, id, err := decodeCursor(cursor, sort)
+	if err != nil {
+		return nil, err
+	}
+	value, err := key.parse(raw)
+	if err != nil {
+		return nil, errCursor
+	}
+	op := "$gt"
+	if desc {
+		op = "$lt"
+	}
+	return f.Or(
+		billing.Filter{}.Where(key.field, op, value),
+		billing.Filter{key.field: value}.Where("id", op, id),
+	), nil
+}
+
+// paginate loads one page of the items matching f, ordered by the query's
+// sort key with ID breaking ties. Filtering, ordering and the page size are
+// left to the database, which is asked for one item more than the page to
+// tell whether another follows.
+func paginate[T any](model database.Model[T], f billing.Filter, q *listQuery, keys map[string]sortKey[T], id func(*T) uuid.UUID) (*listPage[T], error) {
+	name, desc := strings.CutPrefix(q.sort, "-")
+	key, ok := keys[name]
+	if !ok {
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			names = append(names, k)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("sort must be one of %s, optionally prefixed with -", strings.Join(names, ", "))
+	}
+	if q.starting_after != "" {
+		var err error
+		if f, err = after(f, q.starting_after, q.sort, key, desc); err != nil {
+			return nil, err
+		}
+	}
+	opts := append(f.Options(),
+		billing.Sort(key.field, desc),
+		billing.Sort("id", desc),
+		database.WithLimit(int64(q.limit)+1),
+	)
+	items, err := model.Query(opts...).All()
+	if err != nil {
+		return nil, err
+	}
+	page := &listPage[T]{Data: items, HasMore: len(items) > q.limit}
+	if page.HasMore {
+		page.Data = items[:q.limit]
+		last := page.Data[q.limit-1]
+		page.NextCursor = encodeCursor(q.sort, key.cursor(last), id(last))
+	}
+	return page, nil
+}
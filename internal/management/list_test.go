@@ -0,0 +1,71 @@
+package management
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+func TestAfterStartsPastTheCursorsValueAndID(t *testing.T) {
+	// the item the cursor was taken from need not exist any more
+	last := &models.Invoice{ID: uuid.New(), CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)}
+	key := invoice_sort["created_at"]
+	cursor := encodeCursor("-created_at", key.cursor(last), last.ID)
+
+	f, err := after(billing.Filter{"status": models.InvPaid}, cursor, "-created_at", key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := billing.Filter{
+		"status": models.InvPaid,
+		"$or": []map[string]any{
+			{"created_at": map[string]any{"$lt": last.CreatedAt}},
+			{"created_at": last.CreatedAt, "id": map[string]any{"$lt": last.ID}},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("got %v, want %v", f, want)
+	}
+}
+
+func TestAfterRejectsACursorOfAnotherSort(t *testing.T) {
+	key := invoice_sort["amount"]
+	cursor := encodeCursor("amount", "100", uuid.New())
+	if _, err := after(billing.Filter{}, cursor, "-amount", key, true); !errors.Is(err, errCursor) {
+		t.Fatalf("got %v, want errCursor", err)
+	}
+	if _, err := after(billing.Filter{}, "not a cursor", "amount", key, false); !errors.Is(err, errCursor) {
+		t.Fatalf("got %v, want errCursor", err)
+	}
+}
+
+func TestCursorKeepsValuesWithSeparators(t *testing.T) {
+	id := uuid.New()
+	value, got, err := decodeCursor(encodeCursor("last_name", "a\nb", id), "last_name")
+	if err != nil || value != "a\nb" || got != id {
+		t.Fatalf("got %q %s %v", value, got, err)
+	}
+}
+
+func TestRangesMergeOnOneField(t *testing.T) {
+	low, high := int64(100), int64(500)
+	q := &listQuery{
+		created_after:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		created_before: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		amount_min:     &low,
+		amount_max:     &high,
+	}
+	f := q.amount(q.created(billing.Filter{}))
+	want := billing.Filter{
+		"created_at": map[string]any{"$gte": q.created_after, "$lt": q.created_before},
+		"amount":     map[string]any{"$gte": low, "$lte": high},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("got %v, want %v", f, want)
+	}
+}
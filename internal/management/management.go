@@ -1,37 +1,83 @@
 package management
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"strconv"
+	"reflect"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/neghi-go/database"
 	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/checkout"
 	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/payments/processors"
 	"github.com/neghi-go/utilities"
 )
 
-type managementConfig struct{}
+type Option func(*managementConfig)
 
-func NewManagement() *billing.Billing {
+type managementConfig struct {
+	branding documents.Branding
+	checkout *checkout.Links
+}
+
+// WithBranding sets the merchant details printed on invoice and receipt PDFs.
+func WithBranding(branding documents.Branding) Option {
+	return func(m *managementConfig) {
+		m.branding = branding
+	}
+}
+
+// actor is who an invoice change is recorded against when the caller gives no name.
+func actor(name string) string {
+	if name == "" {
+		return "management"
+	}
+	return name
+}
+
+// WithCheckout enables handing out signed links to the hosted invoice page.
+func WithCheckout(links *checkout.Links) Option {
+	return func(m *managementConfig) {
+		m.checkout = links
+	}
+}
+
+func NewManagement(opts ...Option) *billing.Billing {
+	cfg := &managementConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	return &billing.Billing{
 		Name: "customers",
 		Init: func(r chi.Router, ctx *billing.BillingContext) {
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-				var (
-					limit = r.URL.Query().Get("limit")
-				)
-				lim, _ := strconv.Atoi(limit)
-				customers, err := ctx.Customer.Query(database.WithLimit(int64(lim))).All()
+				q, err := parseList(r, "-created_at")
 				if err != nil {
-					utilities.JSON(w).SetStatus(utilities.ResponseError).
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).
 						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 					return
 				}
-				utilities.JSON(w).SetLimit(10).SetPage(1).SetStatusCode(http.StatusOK).
-					SetStatus(utilities.ResponseSuccess).SetData(customers).Send()
+				filter := q.created(billing.Filter{})
+				if email := r.URL.Query().Get("email"); email != "" {
+					filter["email"] = email
+				}
+				page, err := paginate(ctx.Customer, filter, q, customer_sort, func(c *models.Customer) uuid.UUID { return c.ID })
+				if err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					return
+				}
+				utilities.JSON(w).SetLimit(q.limit).SetStatusCode(http.StatusOK).
+					SetStatus(utilities.ResponseSuccess).SetData(page).Send()
 			})
 			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 				var body struct {
@@ -51,6 +97,7 @@ func NewManagement() *billing.Billing {
 					Email:     body.Email,
 					FirstName: body.FirstName,
 					LastName:  body.LastName,
+					CreatedAt: ctx.Clock.Now(),
 				}
 
 				if err := ctx.Customer.Save(newCustomer); err != nil {
@@ -73,49 +120,119 @@ func NewManagement() *billing.Billing {
 					utilities.JSON(w).SetStatusCode(http.StatusOK).
 						SetStatus(utilities.ResponseSuccess).SetData(customers).Send()
 				})
-				r.Patch("/", func(w http.ResponseWriter, r *http.Request) {})
-				r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
-					id := r.PathValue("customer_id")
-					if err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).Delete(); err != nil {
+				r.Patch("/", func(w http.ResponseWriter, r *http.Request) {
+					var body customerBody
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 						utilities.JSON(w).SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 						return
 					}
-
-					//delete invoices, transactions, cards
-					invoices, err := ctx.Invoice.Query(database.WithFilter("customer_id", uuid.MustParse(id))).All()
+					id, err := uuid.Parse(r.PathValue("customer_id"))
 					if err != nil {
-						utilities.JSON(w).SetStatus(utilities.ResponseError).
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusNotFound).SetMessage("Customer not found").Send()
+						return
+					}
+					customer, err := ctx.Customer.Query(database.WithFilter("id", id)).First()
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+						return
+					}
+					before := *processorCustomer(customer)
+					if err := body.apply(customer); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
 							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 						return
 					}
+					if err := changeEmail(ctx.Processor, customer, before.Email); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					// the unique index on email settles concurrent changes to the same address
+					if err := billing.Update(ctx.Customer, customer); billing.IsDuplicate(err) {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusConflict).SetMessage("Another customer already uses this email").Send()
+						return
+					} else if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(billing.StatusCode(err, http.StatusInternalServerError)).SetMessage(err.Error()).Send()
+						return
+					}
 
-					for _, inv := range invoices {
-						if err := ctx.Transactions.Query(database.WithFilter("invoice_id", inv.ID)).DeleteMany(); err != nil {
-							utilities.JSON(w).SetStatus(utilities.ResponseError).
-								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					// the processor's copy follows, ours stands when that fails
+					after := processorCustomer(customer)
+					updater, ok := ctx.Processor.(processors.CustomerUpdater)
+					if ok && customer.ProcessorID != "" && !reflect.DeepEqual(before, *after) {
+						if err := updater.UpdateCustomer(r.Context(), customer.ProcessorID, after); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).SetStatusCode(http.StatusBadGateway).
+								SetMessage("Customer was updated but syncing it to the processor failed: " + err.Error()).
+								SetData(customer).Send()
 							return
 						}
 					}
-					if err := ctx.Invoice.Query(database.WithFilter("customer_id", uuid.MustParse(id))).DeleteMany(); err != nil {
+					utilities.JSON(w).SetStatusCode(http.StatusOK).
+						SetStatus(utilities.ResponseSuccess).SetData(customer).Send()
+				})
+				r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+					id := uuid.MustParse(r.PathValue("customer_id"))
+					invoices, err := ctx.Invoice.Query(database.WithFilter("customer_id", id)).All()
+					if err != nil {
 						utilities.JSON(w).SetStatus(utilities.ResponseError).
 							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 						return
 					}
-					if err := ctx.Card.Query(database.WithFilter("customer_id", uuid.MustParse(id))).DeleteMany(); err != nil {
+
+					held, release, err := lockAll(r.Context(), ctx, invoices)
+					if errors.Is(err, lease.ErrHeld) {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+							SetMessage("An invoice of this customer is being processed, please try again").Send()
+						return
+					}
+					if err != nil {
 						utilities.JSON(w).SetStatus(utilities.ResponseError).
-							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+						return
+					}
+					defer release()
+					r = r.WithContext(held)
+					// an invoice issued before the leases were taken isn't covered by them
+					current, err := ctx.Invoice.Query(database.WithFilter("customer_id", id)).All()
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+						return
+					}
+					if len(current) != len(invoices) || slices.ContainsFunc(current, func(inv *models.Invoice) bool {
+						return !slices.ContainsFunc(invoices, func(locked *models.Invoice) bool { return locked.ID == inv.ID })
+					}) {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+							SetMessage("The customer's invoices changed, please try again").Send()
+						return
+					}
+
+					// dependent records go first
+					unit := ctx.Unit(r.Context())
+					for _, inv := range invoices {
+						billing.DeleteIn(unit, ctx.Transactions, database.WithFilter("invoice_id", inv.ID))
+						billing.DeleteIn(unit, ctx.Invoice, database.WithFilter("id", inv.ID))
+					}
+					billing.DeleteIn(unit, ctx.Card, database.WithFilter("customer_id", id))
+					billing.DeleteIn(unit, ctx.Customer, database.WithFilter("id", id))
+					if err := unit.Commit(); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
 						return
 					}
 
-					utilities.JSON(w).SetStatusCode(http.StatusNoContent).
-						SetStatus(utilities.ResponseSuccess).Send()
+					w.WriteHeader(http.StatusNoContent)
 
 				})
 				r.Route("/cards", func(r chi.Router) {
 					r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 						id := r.PathValue("customer_id")
-						cards, err := ctx.Card.Query(database.WithFilter("customer_id", uuid.MustParse(id))).All()
+						cards, err := ctx.CustomerCards(uuid.MustParse(id))
 						if err != nil {
 							utilities.JSON(w).SetStatus(utilities.ResponseError).
 								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
@@ -124,31 +241,144 @@ func NewManagement() *billing.Billing {
 						utilities.JSON(w).SetStatusCode(http.StatusOK).
 							SetStatus(utilities.ResponseSuccess).SetData(cards).Send()
 					})
+					r.Delete("/{card_id}", func(w http.ResponseWriter, r *http.Request) {
+						customer, cards, card, ok := customerCard(w, r, ctx)
+						if !ok {
+							return
+						}
+						now := ctx.Clock.Now()
+						rest := slices.DeleteFunc(cards, func(c *models.Card) bool { return c.ID == card.ID || !c.Usable(now) })
+
+						// the most recently used card takes over as the default
+						unit := ctx.Unit(r.Context())
+						billing.DeleteIn(unit, ctx.Card, database.WithFilter("id", card.ID))
+						if card.Default && len(rest) > 0 {
+							rest[0].Default = true
+							billing.UpdateIn(unit, ctx.Card, rest[0])
+						}
+						if len(rest) == 0 && customer.HasCard {
+							customer.HasCard = false
+							billing.UpdateIn(unit, ctx.Customer, customer)
+						}
+						if err := unit.Commit(); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(billing.StatusCode(err, http.StatusInternalServerError)).SetMessage(err.Error()).Send()
+							return
+						}
+						w.WriteHeader(http.StatusNoContent)
+					})
+					r.Post("/{card_id}/default", func(w http.ResponseWriter, r *http.Request) {
+						_, cards, card, ok := customerCard(w, r, ctx)
+						if !ok {
+							return
+						}
+						if !card.Usable(ctx.Clock.Now()) {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(billing.ErrCardInactive.Error()).Send()
+							return
+						}
+
+						unit := ctx.Unit(r.Context())
+						for _, c := range cards {
+							if c.Default && c.ID != card.ID {
+								c.Default = false
+								billing.UpdateIn(unit, ctx.Card, c)
+							}
+						}
+						if !card.Default {
+							card.Default = true
+							billing.UpdateIn(unit, ctx.Card, card)
+						}
+						if err := unit.Commit(); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(billing.StatusCode(err, http.StatusInternalServerError)).SetMessage(err.Error()).Send()
+							return
+						}
+						utilities.JSON(w).SetStatusCode(http.StatusOK).
+							SetStatus(utilities.ResponseSuccess).SetData(card).Send()
+					})
 				})
 				r.Route("/invoices", func(r chi.Router) {
 					r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+						q, err := parseList(r, "-created_at")
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
 						id := r.PathValue("customer_id")
-						invoices, err := ctx.Invoice.Query(database.WithFilter("customer_id", uuid.MustParse(id))).All()
+						filter := q.amount(q.created(billing.Filter{"customer_id": uuid.MustParse(id)}))
+						if number := r.URL.Query().Get("number"); number != "" {
+							filter["number"] = number
+						}
+						if status := r.URL.Query().Get("status"); status != "" {
+							filter["status"] = status
+						}
+						page, err := paginate(ctx.Invoice, filter, q, invoice_sort, func(inv *models.Invoice) uuid.UUID { return inv.ID })
 						if err != nil {
-							utilities.JSON(w).SetStatus(utilities.ResponseError).
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
 								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 							return
 						}
 
-						for _, inv := range invoices {
-							transactions, err := ctx.Transactions.Query(database.WithFilter("invoice_id", inv.ID)).All()
+						if expanded(r, "transactions") {
+							ids := make([]uuid.UUID, 0, len(page.Data))
+							for _, inv := range page.Data {
+								ids = append(ids, inv.ID)
+							}
+							transactions, err := ctx.InvoicesTransactions(ids)
 							if err != nil {
 								utilities.JSON(w).SetStatus(utilities.ResponseError).
 									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 								return
 							}
-							inv.Transactions = transactions
+							for _, inv := range page.Data {
+								inv.Transactions = transactions[inv.ID]
+							}
+						}
+
+						utilities.JSON(w).SetLimit(q.limit).SetStatusCode(http.StatusOK).
+							SetStatus(utilities.ResponseSuccess).SetData(page).Send()
+					})
+					r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+						id := r.PathValue("customer_id")
+						var body draftBody
+
+						if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+							return
 						}
 
-						utilities.JSON(w).SetLimit(10).SetPage(1).SetStatusCode(http.StatusOK).
-							SetStatus(utilities.ResponseSuccess).SetData(invoices).Send()
+						invoice := &models.Invoice{
+							ID:         uuid.New(),
+							CustomerID: customer.ID,
+							Status:     models.InvDraft,
+							LineItems:  make([]*models.LineItem, 0),
+							CreatedAt:  ctx.Clock.Now(),
+						}
+						invoice.Number = models.DraftNumber(invoice.ID)
+						if err := body.apply(invoice, ctx.Clock.Now()); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						if err := ctx.Invoice.Save(*invoice); err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						utilities.JSON(w).SetStatusCode(http.StatusCreated).
+							SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
 					})
 					r.Route("/{invoice_id}", func(r chi.Router) {
+						r.Use(locked(ctx))
 						r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 							id := r.PathValue("customer_id")
 							inv_id := r.PathValue("invoice_id")
@@ -161,7 +391,7 @@ func NewManagement() *billing.Billing {
 									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 								return
 							}
-							transactions, err := ctx.Transactions.Query(database.WithFilter("invoice_id", invoice.ID)).All()
+							transactions, err := ctx.InvoiceTransactions(invoice.ID)
 							if err != nil {
 								utilities.JSON(w).SetStatus(utilities.ResponseError).
 									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
@@ -173,18 +403,367 @@ func NewManagement() *billing.Billing {
 								SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
 						})
 						r.Patch("/", func(w http.ResponseWriter, r *http.Request) {
-                        })
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							var body draftBody
+
+							if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", uuid.MustParse(id)),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							if invoice.Status != models.InvDraft {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusConflict).SetMessage("Only draft invoices can be edited").Send()
+								return
+							}
+							if err := body.apply(invoice, ctx.Clock.Now()); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							if err := billing.Update(ctx.Invoice, invoice); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).SetMessage(err.Error()).Send()
+								return
+							}
+							utilities.JSON(w).SetStatusCode(http.StatusOK).
+								SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
+						})
+						r.Post("/finalize", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							var body struct {
+								Actor  string `json:"actor"`
+								Reason string `json:"reason"`
+							}
+
+							if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", customer.ID),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							if invoice.Status != models.InvDraft {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusConflict).SetMessage("Only draft invoices can be finalized").Send()
+								return
+							}
+							now := ctx.Clock.Now()
+							if err := finalize(invoice, actor(body.Actor), body.Reason, now); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							unit := ctx.Unit(r.Context())
+							billing.NumberIn(unit, ctx.Numbering, invoice, now)
+							billing.UpdateIn(unit, ctx.Invoice, invoice)
+							if err := unit.Commit(); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).SetMessage(err.Error()).Send()
+								return
+							}
+							ctx.Publish(r.Context(), events.Event{Type: events.InvoiceIssued, Customer: customer, Invoice: invoice})
+							utilities.JSON(w).SetStatusCode(http.StatusOK).
+								SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
+						})
+						r.Post("/cancel", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							var body struct {
+								Actor  string `json:"actor"`
+								Reason string `json:"reason"`
+							}
+
+							if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", customer.ID),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							if !models.CanTransition(invoice.Status, models.InvCancelled) {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+									SetMessage("Invoice cannot be cancelled from status " + invoice.Status).Send()
+								return
+							}
+
+							// settle a payment that may already be on its way before cancelling
+							transactions, err := ctx.InvoiceTransactions(invoice.ID)
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							for _, trx := range transactions {
+								if trx.Status != models.TrxPending {
+									continue
+								}
+								res, err := ctx.Processor.Verify(r.Context(), trx.Reference)
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+									return
+								}
+								if res == processors.Pending {
+									utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+										SetMessage("Invoice has a payment in progress").Send()
+									return
+								}
+								if err := ctx.Settle(r.Context(), customer, invoice, trx, res); err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).SetMessage(err.Error()).Send()
+									return
+								}
+								if invoice.Status == models.InvPaid {
+									utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+										SetMessage("Invoice has been paid").Send()
+									return
+								}
+							}
+
+							if err := invoice.Transition(models.InvCancelled, actor(body.Actor), body.Reason, ctx.Clock.Now()); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusConflict).SetMessage(err.Error()).Send()
+								return
+							}
+							if err := billing.Update(ctx.Invoice, invoice); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(billing.StatusCode(err, http.StatusBadRequest)).SetMessage(err.Error()).Send()
+								return
+							}
+							ctx.Publish(r.Context(), events.Event{Type: events.InvoiceCancelled, Customer: customer, Invoice: invoice})
+							utilities.JSON(w).SetStatusCode(http.StatusOK).
+								SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
+						})
+						r.Post("/void", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							var body struct {
+								Actor  string `json:"actor"`
+								Reason string `json:"reason"`
+							}
+
+							if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", customer.ID),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							if !models.CanTransition(invoice.Status, models.InvVoid) {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+									SetMessage("Only paid invoices can be voided").Send()
+								return
+							}
+							// transactions stored before refund_due existed don't have the field
+							paid := billing.Filter{"invoice_id": invoice.ID}.
+								Where("status", "$in", []string{models.TrxSuccess, models.TrxRefundPending}).
+								Where("refund_due", "$ne", true)
+							trx, err := ctx.Transactions.Query(paid.Options()...).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+
+							// the refund is recorded as pending before the processor sees it, a
+							// void retried after a failure asks the processor whether it went through
+							refund := true
+							if trx.Status == models.TrxSuccess {
+								trx.SetStatus(models.TrxRefundPending, ctx.Clock.Now())
+								if err := billing.Update(ctx.Transactions, trx); err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(billing.StatusCode(err, http.StatusInternalServerError)).SetMessage(err.Error()).Send()
+									return
+								}
+							} else {
+								res, err := ctx.Processor.Verify(r.Context(), trx.Reference)
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusBadGateway).SetMessage(err.Error()).Send()
+									return
+								}
+								refund = res != processors.Reversed
+							}
+							if refund {
+								if err := ctx.Processor.Refund(r.Context(), trx.ID); err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).SetStatusCode(http.StatusBadGateway).
+										SetMessage("Refund failed, void the invoice again to retry: " + err.Error()).Send()
+									return
+								}
+							}
+
+							trx.SetStatus(models.TrxRefunded, ctx.Clock.Now())
+							if err := invoice.Transition(models.InvVoid, actor(body.Actor), body.Reason, ctx.Clock.Now()); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusConflict).SetMessage(err.Error()).Send()
+								return
+							}
+							unit := ctx.Unit(r.Context())
+							billing.UpdateIn(unit, ctx.Transactions, trx)
+							billing.UpdateIn(unit, ctx.Invoice, invoice)
+							if err := unit.Commit(); err != nil {
+								// the transaction stays REFUND_PENDING, voiding again finishes the job
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusInternalServerError).
+									SetMessage("Refund was issued but recording it failed, void the invoice again to finish: " + err.Error()).Send()
+								return
+							}
+							ctx.Publish(r.Context(), events.Event{Type: events.RefundProcessed, Customer: customer, Invoice: invoice, Transaction: trx})
+							utilities.JSON(w).SetStatusCode(http.StatusOK).
+								SetStatus(utilities.ResponseSuccess).SetData(invoice).Send()
+						})
+						r.Get("/checkout", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							if cfg.checkout == nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).
+									SetStatusCode(http.StatusNotFound).SetMessage("Hosted checkout is not enabled").Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", uuid.MustParse(id)),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							if invoice.Status == models.InvDraft {
+								utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+									SetMessage("Invoice is still a draft, finalize it before sharing").Send()
+								return
+							}
+							utilities.JSON(w).SetStatusCode(http.StatusOK).SetStatus(utilities.ResponseSuccess).
+								SetData(map[string]string{"url": cfg.checkout.URL(invoice.ID, ctx.Clock.Now())}).Send()
+						})
+						r.Get("/pdf", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", customer.ID),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							transactions, err := ctx.InvoiceTransactions(invoice.ID)
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							invoice.Transactions = transactions
+
+							buf := &bytes.Buffer{}
+							if err := documents.RenderInvoice(buf, cfg.branding, customer, invoice); err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+								return
+							}
+							sendPDF(w, "invoice-"+invoice.ID.String()+".pdf", buf)
+						})
+						r.Get("/timeline", func(w http.ResponseWriter, r *http.Request) {
+							id := r.PathValue("customer_id")
+							inv_id := r.PathValue("invoice_id")
+							invoice, err := ctx.Invoice.Query(
+								database.WithFilter("customer_id", uuid.MustParse(id)),
+								database.WithFilter("id", uuid.MustParse(inv_id)),
+							).First()
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+								return
+							}
+							transactions, err := ctx.InvoiceTransactions(invoice.ID)
+							if err != nil {
+								utilities.JSON(w).SetStatus(utilities.ResponseError).
+									SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+								return
+							}
+							utilities.JSON(w).SetStatusCode(http.StatusOK).
+								SetStatus(utilities.ResponseSuccess).SetData(timeline(invoice, transactions)).Send()
+						})
 						r.Route("/transactions", func(r chi.Router) {
 							r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+								q, err := parseList(r, "created_at")
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseFail).
+										SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+									return
+								}
 								id := r.PathValue("invoice_id")
-								transactions, err := ctx.Transactions.Query(database.WithFilter("invoice_id", uuid.MustParse(id))).All()
+								filter := q.created(billing.Filter{"invoice_id": uuid.MustParse(id)})
+								if status := r.URL.Query().Get("status"); status != "" {
+									filter["status"] = status
+								}
+								page, err := paginate(ctx.Transactions, filter, q, transaction_sort, func(trx *models.Transaction) uuid.UUID { return trx.ID })
 								if err != nil {
-									utilities.JSON(w).SetStatus(utilities.ResponseError).
+									utilities.JSON(w).SetStatus(utilities.ResponseFail).
 										SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
 									return
 								}
-								utilities.JSON(w).SetLimit(10).SetPage(1).SetStatusCode(http.StatusOK).
-									SetStatus(utilities.ResponseSuccess).SetData(transactions).Send()
+								utilities.JSON(w).SetLimit(q.limit).SetStatusCode(http.StatusOK).
+									SetStatus(utilities.ResponseSuccess).SetData(page).Send()
 							})
 							r.Get("/{trx_id}", func(w http.ResponseWriter, r *http.Request) {
 								id := r.PathValue("invoice_id")
@@ -202,6 +781,48 @@ func NewManagement() *billing.Billing {
 								utilities.JSON(w).SetStatusCode(http.StatusOK).
 									SetStatus(utilities.ResponseSuccess).SetData(transaction).Send()
 							})
+							r.Get("/{trx_id}/receipt", func(w http.ResponseWriter, r *http.Request) {
+								id := r.PathValue("customer_id")
+								inv_id := r.PathValue("invoice_id")
+								trx_id := r.PathValue("trx_id")
+								customer, err := ctx.Customer.Query(database.WithFilter("id", uuid.MustParse(id))).First()
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+									return
+								}
+								invoice, err := ctx.Invoice.Query(
+									database.WithFilter("customer_id", customer.ID),
+									database.WithFilter("id", uuid.MustParse(inv_id)),
+								).First()
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+									return
+								}
+								transaction, err := ctx.Transactions.Query(
+									database.WithFilter("invoice_id", invoice.ID),
+									database.WithFilter("id", uuid.MustParse(trx_id)),
+								).First()
+								if err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+									return
+								}
+								if transaction.Status != models.TrxSuccess {
+									utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+										SetMessage("Receipts are only available for successful transactions").Send()
+									return
+								}
+
+								buf := &bytes.Buffer{}
+								if err := documents.RenderReceipt(buf, cfg.branding, customer, invoice, transaction); err != nil {
+									utilities.JSON(w).SetStatus(utilities.ResponseError).
+										SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+									return
+								}
+								sendPDF(w, "receipt-"+transaction.Reference+".pdf", buf)
+							})
 						})
 					})
 				})
@@ -1,14 +1,62 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Card struct {
-	ID         uuid.UUID `json:"-" db:"id,index,unique,required"`
-	CustomerID string    `json:"-" db:"customer_id,index"`
+	ID         uuid.UUID `json:"id" db:"id,index,unique,required"`
+	CustomerID uuid.UUID `json:"customer_id" db:"customer_id,index"`
 	AuthKey    string    `json:"-" db:"auth_key"`
-	LastUsed   time.Time `json:"last_used" db:"last_used"`
+
+	// display details, so customers can tell their cards apart
+	Brand    string `json:"brand" db:"brand"`
+	Last4    string `json:"last4" db:"last4"`
+	ExpMonth int    `json:"exp_month" db:"exp_month"`
+	ExpYear  int    `json:"exp_year" db:"exp_year"`
+	Bank     string `json:"bank" db:"bank"`
+
+	// Default is charged when a charge names no card.
+	Default bool `json:"default" db:"default"`
+	// DeactivatedAt is set once the card has expired, it is never charged again.
+	DeactivatedAt  time.Time `json:"deactivated_at" db:"deactivated_at"`
+	ExpiryNotified bool      `json:"-" db:"expiry_notified"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	LastUsed       time.Time `json:"last_used" db:"last_used"`
+	Version        int64     `json:"version" db:"version"`
+	Revision       string    `json:"-" db:"revision"`
+}
+
+// ExpiresAt is the first instant the card can no longer be charged, the start
+// of the month after its expiry month. It is zero when the expiry is unknown.
+func (c *Card) ExpiresAt() time.Time {
+	if c.ExpYear == 0 || c.ExpMonth < 1 || c.ExpMonth > 12 {
+		return time.Time{}
+	}
+	return time.Date(c.ExpYear, time.Month(c.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Usable reports whether the card can be charged at now.
+func (c *Card) Usable(now time.Time) bool {
+	if !c.DeactivatedAt.IsZero() {
+		return false
+	}
+	expires := c.ExpiresAt()
+	return expires.IsZero() || now.Before(expires)
+}
+
+// SortCards puts the default card first, then the rest most recently used first.
+func SortCards(cards []*Card) {
+	slices.SortStableFunc(cards, func(a, b *Card) int {
+		if a.Default != b.Default {
+			if a.Default {
+				return -1
+			}
+			return 1
+		}
+		return b.LastUsed.Compare(a.LastUsed)
+	})
 }
@@ -0,0 +1,51 @@
+package models
+
+import "maps"
+
+// clone copies every element of items, keeping a nil slice nil.
+func clone[T any](items []*T, dup func(*T) *T) []*T {
+	if items == nil {
+		return nil
+	}
+	out := make([]*T, len(items))
+	for i, item := range items {
+		if item != nil {
+			out[i] = dup(item)
+		}
+	}
+	return out
+}
+
+func copyOf[T any](v *T) *T {
+	c := *v
+	return &c
+}
+
+// Clone returns a copy of the customer sharing no memory with it.
+func (c *Customer) Clone() *Customer {
+	out := *c
+	out.Metadata = maps.Clone(c.Metadata)
+	return &out
+}
+
+// Clone returns a copy of the invoice sharing no memory with it, its
+// transactions included.
+func (i *Invoice) Clone() *Invoice {
+	out := *i
+	out.LineItems = clone(i.LineItems, copyOf[LineItem])
+	out.History = clone(i.History, copyOf[InvoiceTransition])
+	out.Transactions = clone(i.Transactions, (*Transaction).Clone)
+	return &out
+}
+
+// Clone returns a copy of the transaction sharing no memory with it.
+func (t *Transaction) Clone() *Transaction {
+	out := *t
+	out.History = clone(t.History, copyOf[TransactionStatus])
+	return &out
+}
+
+// Clone returns a copy of the card.
+func (c *Card) Clone() *Card {
+	return copyOf(c)
+}
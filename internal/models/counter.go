@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Counter is the head of a numbering sequence, its ID is the scope.
+type Counter struct {
+	ID        string    `json:"id" db:"id,index,unique,required"`
+	Scope     string    `json:"scope" db:"scope,index"`
+	Seq       int64     `json:"seq" db:"seq"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Pending is the number reserved for a write still in flight, Seq is
+	// only moved past it once the write is known to have landed.
+	Pending string `json:"pending" db:"pending"`
+}
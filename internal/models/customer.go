@@ -1,12 +1,37 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Customer struct {
 	ID        uuid.UUID `json:"id" db:"id,index,unique,required"`
 	FirstName string    `json:"first_name" db:"first_name"`
 	LastName  string    `json:"last_name" db:"last_name"`
 	Email     string    `json:"email" db:"email,index,required,unique"`
+	Phone     string    `json:"phone" db:"phone"`
+	// Country is an ISO 3166-1 alpha-2 code and Currency an ISO 4217 code.
+	Country  string            `json:"country" db:"country"`
+	Currency string            `json:"currency" db:"currency"`
+	Address  *Address          `json:"address,omitempty" db:"address"`
+	Metadata map[string]string `json:"metadata,omitempty" db:"metadata"`
+	// ProcessorID is the customer's code at the payment processor, once known.
+	ProcessorID string `json:"processor_id,omitempty" db:"processor_id"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	HasCard   bool      `json:"-" db:"has_card"`
+	Version   int64     `json:"version" db:"version"`
+	Revision  string    `json:"-" db:"revision"`
+}
 
-	HasCard bool `json:"-" db:"has_card"`
+// Address is a customer's billing address.
+type Address struct {
+	Line1      string `json:"line1" db:"line1"`
+	Line2      string `json:"line2" db:"line2"`
+	City       string `json:"city" db:"city"`
+	State      string `json:"state" db:"state"`
+	PostalCode string `json:"postal_code" db:"postal_code"`
+	Country    string `json:"country" db:"country"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers the response to a mutating request so a retry
+// carrying the same Idempotency-Key header gets it replayed.
+type IdempotencyKey struct {
+	ID          string            `json:"id" db:"id,index,unique,required"`
+	Method      string            `json:"method" db:"method"`
+	Path        string            `json:"path" db:"path"`
+	RequestHash string            `json:"request_hash" db:"request_hash"`
+	Completed   bool              `json:"completed" db:"completed"`
+	StatusCode  int               `json:"status_code" db:"status_code"`
+	Header      map[string]string `json:"header" db:"header"`
+	Body        []byte            `json:"body" db:"body"`
+	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at" db:"expires_at,index"`
+	// Token identifies the request holding the key, it changes whenever a
+	// request claims the key.
+	Token string `json:"-" db:"token"`
+}
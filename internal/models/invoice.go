@@ -1,28 +1,124 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 var (
-	InvDraft     string = "DRAFT"
-	InvIssued    string = "ISSUED"
-	InvPaid      string = "PAID"
-	InvExpired   string = "EXPIRED"
-	InvCancelled string = "CANCELLED"
+	InvDraft         string = "DRAFT"
+	InvIssued        string = "ISSUED"
+	InvPaid          string = "PAID"
+	InvExpired       string = "EXPIRED"
+	InvCancelled     string = "CANCELLED"
+	InvUncollectible string = "UNCOLLECTIBLE"
+	InvVoid          string = "VOID"
 )
 
+var ErrInvalidTransition = errors.New("invalid invoice status transition")
+
+// invoiceTransitions lists the statuses each status may move to, statuses
+// missing from it are final.
+//
+// EXPIRED and UNCOLLECTIBLE invoices can still become PAID, but only through
+// Settle verifying a transaction started while the invoice was ISSUED. The
+// money was taken, so the invoice records it. No charge can be started on
+// them, checkout and the charge routes refuse anything but ISSUED.
+var invoiceTransitions = map[string][]string{
+	InvDraft:         {InvIssued, InvCancelled},
+	InvIssued:        {InvPaid, InvExpired, InvCancelled, InvUncollectible},
+	InvUncollectible: {InvPaid, InvCancelled},
+	InvExpired:       {InvPaid},
+	InvPaid:          {InvVoid},
+}
+
+type InvoiceTransition struct {
+	From   string    `json:"from" db:"from"`
+	To     string    `json:"to" db:"to"`
+	Actor  string    `json:"actor" db:"actor"`
+	Reason string    `json:"reason" db:"reason"`
+	At     time.Time `json:"at" db:"at"`
+}
+
+type LineItem struct {
+	Description string `json:"description" db:"description"`
+	Quantity    int64  `json:"quantity" db:"quantity"`
+	UnitAmount  int64  `json:"unit_amount" db:"unit_amount"`
+}
+
+func (l *LineItem) Total() int64 {
+	return l.Quantity * l.UnitAmount
+}
+
 type Invoice struct {
-	ID           uuid.UUID      `json:"id" db:"id,index,unique"`
-	CustomerID   uuid.UUID      `json:"customer_id" db:"customer_id,index"`
-	Amount       int64          `json:"amount" db:"amount"`
-	Description  string         `json:"description" db:"description"`
-	Status       string         `json:"status" db:"status"`
-	LastAttempt  time.Time      `json:"last_attempt" db:"last_attempt"`
-	AttemptCount int64          `json:"-" db:"attempt_count"`
-	PaidAt       time.Time      `json:"paid_at" db:"paid_at"`
-	ExpiresAt    time.Time      `json:"expires_at" db:"expires_at"`
-	Transactions []*Transaction `json:"transactions" db:"-"`
+	ID           uuid.UUID            `json:"id" db:"id,index,unique"`
+	Number       string               `json:"number" db:"number,index,unique"`
+	CustomerID   uuid.UUID            `json:"customer_id" db:"customer_id,index"`
+	LinkID       uuid.UUID            `json:"link_id" db:"link_id,index"`
+	Amount       int64                `json:"amount" db:"amount"`
+	Description  string               `json:"description" db:"description"`
+	LineItems    []*LineItem          `json:"line_items" db:"line_items"`
+	Tax          int64                `json:"tax" db:"tax"`
+	DueDate      time.Time            `json:"due_date" db:"due_date"`
+	CreatedAt    time.Time            `json:"created_at" db:"created_at"`
+	Status       string               `json:"status" db:"status"`
+	LastAttempt  time.Time            `json:"last_attempt" db:"last_attempt"`
+	AttemptCount int64                `json:"-" db:"attempt_count"`
+	MaxAttempts  int64                `json:"-" db:"max_attempts"`
+	PaidAt       time.Time            `json:"paid_at" db:"paid_at"`
+	ExpiresAt    time.Time            `json:"expires_at" db:"expires_at"`
+	DunningStart time.Time            `json:"-" db:"dunning_start"`
+	DunningStep  int64                `json:"-" db:"dunning_step"`
+	NextRetry    time.Time            `json:"-" db:"next_retry,index"`
+	History      []*InvoiceTransition `json:"history" db:"history"`
+	Transactions []*Transaction       `json:"transactions" db:"-"`
+	Version      int64                `json:"version" db:"version"`
+	Revision     string               `json:"-" db:"revision"`
+}
+
+// DraftNumber is the number a draft carries until it is finalised. Numbers
+// are unique, so drafts can't all leave it empty.
+func DraftNumber(id uuid.UUID) string {
+	return draft_prefix + id.String()
+}
+
+const draft_prefix = "DRAFT-"
+
+// Numbered reports whether the invoice was given its number.
+func (i *Invoice) Numbered() bool {
+	return i.Number != "" && !strings.HasPrefix(i.Number, draft_prefix)
+}
+
+// AttemptsExhausted reports whether the invoice has used up its payment
+// attempts, a zero MaxAttempts allows any number.
+func (i *Invoice) AttemptsExhausted() bool {
+	return i.MaxAttempts > 0 && i.AttemptCount >= i.MaxAttempts
+}
+
+// CanTransition reports whether an invoice may move from one status to another.
+func CanTransition(from, to string) bool {
+	return slices.Contains(invoiceTransitions[from], to)
+}
+
+// Transition moves the invoice to status to and records who did it, why and when.
+// It returns ErrInvalidTransition, leaving the invoice untouched, when the
+// move is not allowed.
+func (i *Invoice) Transition(to, actor, reason string, at time.Time) error {
+	if !CanTransition(i.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, i.Status, to)
+	}
+	i.History = append(i.History, &InvoiceTransition{
+		From:   i.Status,
+		To:     to,
+		Actor:  actor,
+		Reason: reason,
+		At:     at.UTC(),
+	})
+	i.Status = to
+	return nil
 }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	allowed := [][2]string{
+		{InvDraft, InvIssued}, {InvDraft, InvCancelled},
+		{InvIssued, InvPaid}, {InvIssued, InvExpired}, {InvIssued, InvCancelled}, {InvIssued, InvUncollectible},
+		{InvUncollectible, InvPaid}, {InvUncollectible, InvCancelled},
+		{InvExpired, InvPaid},
+		{InvPaid, InvVoid},
+	}
+	for _, move := range allowed {
+		if !CanTransition(move[0], move[1]) {
+			t.Errorf("%s to %s refused", move[0], move[1])
+		}
+	}
+	refused := [][2]string{
+		{InvDraft, InvPaid}, {InvIssued, InvDraft}, {InvIssued, InvIssued}, {InvPaid, InvCancelled},
+		{InvExpired, InvIssued}, {InvCancelled, InvIssued}, {InvVoid, InvPaid}, {"UNKNOWN", InvPaid},
+	}
+	for _, move := range refused {
+		if CanTransition(move[0], move[1]) {
+			t.Errorf("%s to %s allowed", move[0], move[1])
+		}
+	}
+}
+
+func TestTransitionRecordsHistory(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.FixedZone("WAT", 3600))
+	inv := &Invoice{Status: InvDraft}
+	if err := inv.Transition(InvIssued, "admin", "finalized", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.Transition(InvPaid, "system", "", at.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != InvPaid || len(inv.History) != 2 {
+		t.Fatalf("got %s with %d transitions", inv.Status, len(inv.History))
+	}
+	first := inv.History[0]
+	if first.From != InvDraft || first.To != InvIssued || first.Actor != "admin" || first.Reason != "finalized" {
+		t.Errorf("got %+v", first)
+	}
+	if first.At.Location() != time.UTC || !first.At.Equal(at) {
+		t.Errorf("got %v, want %v in UTC", first.At, at)
+	}
+}
+
+func TestClosedInvoicesOnlyTakeALatePayment(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, status := range []string{InvExpired, InvUncollectible} {
+		inv := &Invoice{Status: status}
+		if err := inv.Transition(InvIssued, "admin", "reopened", at); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s: got %v, want the invoice to stay closed", status, err)
+		}
+		if err := inv.Transition(InvPaid, "processor", "verified late", at); err != nil {
+			t.Fatalf("%s: %v", status, err)
+		}
+		if inv.Status != InvPaid || len(inv.History) != 1 || inv.History[0].From != status {
+			t.Errorf("%s: got %s with %+v", status, inv.Status, inv.History)
+		}
+	}
+}
+
+func TestTransitionRefusalLeavesTheInvoice(t *testing.T) {
+	inv := &Invoice{Status: InvPaid}
+	err := inv.Transition(InvCancelled, "admin", "", time.Now())
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("got %v, want ErrInvalidTransition", err)
+	}
+	if inv.Status != InvPaid || len(inv.History) != 0 {
+		t.Fatalf("got %s with %d transitions", inv.Status, len(inv.History))
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Lease grants its owner exclusive use of a resource, such as
+// "invoice:<id>", until ExpiresAt.
+type Lease struct {
+	ID        string    `json:"id" db:"id,index,unique,required"`
+	Owner     string    `json:"owner" db:"owner"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	// Token changes with every write, reading it back tells whose write landed.
+	Token string `json:"token" db:"token"`
+}
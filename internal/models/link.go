@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentLink is a shareable /pay/{slug} URL that opens a fresh invoice for
+// whoever uses it.
+type PaymentLink struct {
+	ID          uuid.UUID `json:"id" db:"id,index,unique,required"`
+	Slug        string    `json:"slug" db:"slug,index,unique,required"`
+	Description string    `json:"description" db:"description"`
+	// Amount is fixed when set, otherwise the customer enters one of at least MinAmount.
+	Amount     int64     `json:"amount" db:"amount"`
+	MinAmount  int64     `json:"min_amount" db:"min_amount"`
+	UsageLimit int64     `json:"usage_limit" db:"usage_limit"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+	Active     bool      `json:"active" db:"active"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// Uses counts the invoices opened through the link and Conversions those
+	// paid. Open counts the issued ones, each holds one of UsageLimit's slots
+	// until it is paid or closed.
+	Uses        int64  `json:"uses" db:"uses"`
+	Conversions int64  `json:"conversions" db:"conversions"`
+	Open        int64  `json:"open" db:"open"`
+	Version     int64  `json:"version" db:"version"`
+	Revision    string `json:"-" db:"revision"`
+}
@@ -1,17 +1,84 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 var (
 	TrxPending    string = "PENDING"
 	TrxSuccess    string = "SUCCESS"
 	TrxFailed     string = "FAILED"
 	TrxAbandonned string = "ABANDONED"
+	TrxRefunded   string = "REFUNDED"
+	// TrxRefundPending marks a refund handed to the processor but not yet
+	// recorded, so a retried void checks the processor instead of refunding again.
+	TrxRefundPending string = "REFUND_PENDING"
 )
 
+// TransactionStatus records a status a transaction entered and when.
+type TransactionStatus struct {
+	Status string    `json:"status" db:"status"`
+	At     time.Time `json:"at" db:"at"`
+}
+
 type Transaction struct {
 	ID        uuid.UUID `json:"id" db:"id,index,unique"`
 	InvoiceID uuid.UUID `json:"invoice_id" db:"invoice_id,index"`
 	Reference string    `json:"reference" db:"reference"`
 	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"created_at" db:"created_at,index"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// CompletedAt is when the transaction left PENDING.
+	CompletedAt time.Time            `json:"completed_at" db:"completed_at"`
+	History     []*TransactionStatus `json:"history" db:"history"`
+	// AuthorizationURL is the processor's hosted payment page for the
+	// transaction, a customer coming back while it is pending is sent there again.
+	AuthorizationURL string `json:"-" db:"authorization_url"`
+	// RefundDue flags a successful payment its invoice could not take, because
+	// another one paid it first or it was closed, the money has to go back.
+	RefundDue bool `json:"refund_due" db:"refund_due"`
+	// VerifyAttempts and NextVerify pace background verification of a pending transaction.
+	VerifyAttempts int64     `json:"-" db:"verify_attempts"`
+	NextVerify     time.Time `json:"-" db:"next_verify,index"`
+	Version        int64     `json:"version" db:"version"`
+	Revision       string    `json:"-" db:"revision"`
+}
+
+// NewTransaction starts a pending transaction on an invoice.
+func NewTransaction(invoice_id uuid.UUID, reference string, at time.Time) *Transaction {
+	at = at.UTC()
+	return &Transaction{
+		ID:        uuid.New(),
+		InvoiceID: invoice_id,
+		Reference: reference,
+		Status:    TrxPending,
+		CreatedAt: at,
+		UpdatedAt: at,
+		History:   []*TransactionStatus{{Status: TrxPending, At: at}},
+	}
+}
+
+// SetStatus moves the transaction to status, recording the change in History.
+func (t *Transaction) SetStatus(status string, at time.Time) {
+	if status == t.Status {
+		return
+	}
+	at = at.UTC()
+	if t.Status == TrxPending {
+		t.CompletedAt = at
+	}
+	t.Status = status
+	t.UpdatedAt = at
+	t.History = append(t.History, &TransactionStatus{Status: status, At: at})
+}
+
+// SortTransactions orders transactions oldest first. Ties, and transactions
+// stored before CreatedAt existed, keep the order they were given in.
+func SortTransactions(tranx []*Transaction) {
+	slices.SortStableFunc(tranx, func(a, b *Transaction) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
 }
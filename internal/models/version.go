@@ -0,0 +1,33 @@
+package models
+
+import "github.com/google/uuid"
+
+// Versioned documents carry a version that every update bumps, so a write
+// based on a stale read can be detected instead of clobbering a newer one.
+// Each update also stamps a random revision, reading it back tells which of
+// two racing writes landed.
+type Versioned interface {
+	VersionRef() (id uuid.UUID, version int64, revision string)
+	SetVersion(version int64, revision string)
+}
+
+func (i *Invoice) VersionRef() (uuid.UUID, int64, string) { return i.ID, i.Version, i.Revision }
+func (i *Invoice) SetVersion(version int64, revision string) {
+	i.Version, i.Revision = version, revision
+}
+func (t *Transaction) VersionRef() (uuid.UUID, int64, string) { return t.ID, t.Version, t.Revision }
+func (t *Transaction) SetVersion(version int64, revision string) {
+	t.Version, t.Revision = version, revision
+}
+func (c *Customer) VersionRef() (uuid.UUID, int64, string) { return c.ID, c.Version, c.Revision }
+func (c *Customer) SetVersion(version int64, revision string) {
+	c.Version, c.Revision = version, revision
+}
+func (c *Card) VersionRef() (uuid.UUID, int64, string) { return c.ID, c.Version, c.Revision }
+func (c *Card) SetVersion(version int64, revision string) {
+	c.Version, c.Revision = version, revision
+}
+func (l *PaymentLink) VersionRef() (uuid.UUID, int64, string) { return l.ID, l.Version, l.Revision }
+func (l *PaymentLink) SetVersion(version int64, revision string) {
+	l.Version, l.Revision = version, revision
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	DeliveryPending   string = "PENDING"
+	DeliverySucceeded string = "SUCCEEDED"
+	DeliveryFailed    string = "FAILED"
+)
+
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id" db:"id,index,unique,required"`
+	URL       string    `json:"url" db:"url"`
+	Secret    string    `json:"-" db:"secret"`
+	Events    []string  `json:"events" db:"events"`
+	Active    bool      `json:"active" db:"active"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type WebhookAttempt struct {
+	At         time.Time `json:"at" db:"at"`
+	StatusCode int       `json:"status_code" db:"status_code"`
+	Response   string    `json:"response" db:"response"`
+	Error      string    `json:"error,omitempty" db:"error"`
+}
+
+type WebhookDelivery struct {
+	ID          uuid.UUID         `json:"id" db:"id,index,unique,required"`
+	EndpointID  uuid.UUID         `json:"endpoint_id" db:"endpoint_id,index"`
+	EventID     uuid.UUID         `json:"event_id" db:"event_id,index"`
+	Event       string            `json:"event" db:"event"`
+	Payload     string            `json:"payload" db:"payload"`
+	Status      string            `json:"status" db:"status,index"`
+	Attempts    []*WebhookAttempt `json:"attempts" db:"attempts"`
+	NextAttempt time.Time         `json:"next_attempt" db:"next_attempt"`
+	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+	// Token changes whenever an instance claims the delivery to send it.
+	Token string `json:"-" db:"token"`
+}
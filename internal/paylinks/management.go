@@ -0,0 +1,108 @@
+package paylinks
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/utils"
+	"github.com/neghi-go/utilities"
+)
+
+var slug_pattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+// NewLinkManagement lets the merchant create, list and deactivate payment links.
+func NewLinkManagement() *billing.Billing {
+	return &billing.Billing{
+		Name: "links",
+		Init: func(r chi.Router, ctx *billing.BillingContext) {
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				links, err := ctx.Links.Query().All()
+				if err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseError).
+						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					return
+				}
+				utilities.JSON(w).SetStatusCode(http.StatusOK).
+					SetStatus(utilities.ResponseSuccess).SetData(links).Send()
+			})
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Slug        string    `json:"slug"`
+					Description string    `json:"description"`
+					Amount      int64     `json:"amount"`
+					MinAmount   int64     `json:"min_amount"`
+					UsageLimit  int64     `json:"usage_limit"`
+					ExpiresAt   time.Time `json:"expires_at"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseError).
+						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					return
+				}
+				if body.Slug == "" {
+					body.Slug = utils.GenerateReference(5)
+				}
+				if !slug_pattern.MatchString(body.Slug) {
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusBadRequest).
+						SetMessage("slug must be 2-64 lowercase letters, digits or dashes").Send()
+					return
+				}
+				if body.Amount < 0 || body.MinAmount < 0 || body.UsageLimit < 0 {
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusBadRequest).
+						SetMessage("amount, min_amount and usage_limit can't be negative").Send()
+					return
+				}
+				if !body.ExpiresAt.IsZero() && body.ExpiresAt.Before(ctx.Clock.Now()) {
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusBadRequest).
+						SetMessage("expires_at must be in the future").Send()
+					return
+				}
+				if _, err := ctx.Links.Query(database.WithFilter("slug", body.Slug)).First(); err == nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).SetStatusCode(http.StatusConflict).
+						SetMessage("slug is already taken").Send()
+					return
+				}
+
+				link := models.PaymentLink{
+					ID:          uuid.New(),
+					Slug:        body.Slug,
+					Description: body.Description,
+					Amount:      body.Amount,
+					MinAmount:   body.MinAmount,
+					UsageLimit:  body.UsageLimit,
+					ExpiresAt:   body.ExpiresAt.UTC(),
+					Active:      true,
+					CreatedAt:   ctx.Clock.Now(),
+				}
+				if err := ctx.Links.Save(link); err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseError).
+						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					return
+				}
+				utilities.JSON(w).SetStatusCode(http.StatusCreated).
+					SetStatus(utilities.ResponseSuccess).SetData(link).Send()
+			})
+			r.Post("/{link_id}/deactivate", func(w http.ResponseWriter, r *http.Request) {
+				id := uuid.MustParse(r.PathValue("link_id"))
+				link, err := change(ctx, id, func(link *models.PaymentLink) bool {
+					link.Active = false
+					return true
+				})
+				if err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseError).
+						SetStatusCode(billing.StatusCode(err, http.StatusNotFound)).SetMessage(err.Error()).Send()
+					return
+				}
+				utilities.JSON(w).SetStatusCode(http.StatusOK).
+					SetStatus(utilities.ResponseSuccess).SetData(link).Send()
+			})
+		},
+	}
+}
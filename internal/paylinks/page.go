@@ -0,0 +1,94 @@
+package paylinks
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var page = template.Must(template.New("pay").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Merchant}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; background: #f4f5f7; margin: 0; color: #212529; }
+header { background: {{.Primary}}; color: #fff; padding: 20px 24px; font-size: 1.25em; font-weight: bold; }
+main { max-width: 480px; margin: 24px auto; background: #fff; padding: 24px; border-radius: 8px; }
+label { display: block; margin: 12px 0 4px; font-size: 0.9em; }
+input { width: 100%; box-sizing: border-box; padding: 10px; border: 1px solid {{.Accent}}; border-radius: 4px; font-size: 1em; }
+.amount { font-size: 1.5em; font-weight: bold; margin: 8px 0 16px; }
+.error { padding: 12px 16px; border-radius: 4px; margin-bottom: 16px; background: #fdecea; }
+button { margin-top: 20px; background: {{.Primary}}; color: #fff; border: 0; padding: 14px; border-radius: 4px; font-size: 1em; width: 100%; cursor: pointer; }
+</style>
+</head>
+<body>
+<header>{{.Merchant}}</header>
+<main>
+{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+{{if .Open}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Amount}}<div class="amount">{{.Amount}}</div>{{end}}
+<form method="post">
+<label for="email">Email</label>
+<input id="email" name="email" type="email" required value="{{.Email}}">
+<label for="first_name">First name</label>
+<input id="first_name" name="first_name" required value="{{.FirstName}}">
+<label for="last_name">Last name</label>
+<input id="last_name" name="last_name" value="{{.LastName}}">
+{{if not .Amount}}
+<label for="amount">Amount{{if .Minimum}} (at least {{.Minimum}}){{end}}</label>
+<input id="amount" name="amount" inputmode="decimal" required value="{{.Entered}}">
+{{end}}
+<button type="submit">Continue to payment</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type view struct {
+	Merchant    string
+	Primary     string
+	Accent      string
+	Open        bool
+	Error       string
+	Description string
+	Amount      string
+	Minimum     string
+	Email       string
+	FirstName   string
+	LastName    string
+	Entered     string
+}
+
+func newView(branding documents.Branding, link *models.PaymentLink) *view {
+	v := &view{
+		Merchant: branding.Name,
+		Primary:  branding.PrimaryColor.Hex(),
+		Accent:   branding.AccentColor.Hex(),
+	}
+	if link == nil {
+		return v
+	}
+	v.Open = true
+	v.Description = link.Description
+	if link.Amount > 0 {
+		v.Amount = branding.Money(link.Amount)
+	}
+	if link.MinAmount > 0 {
+		v.Minimum = branding.Money(link.MinAmount)
+	}
+	return v
+}
+
+func (v *view) render(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page.Execute(w, v)
+}
@@ -0,0 +1,289 @@
+package paylinks
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/checkout"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// expiry is how long the invoice opened by a link stays payable.
+var expiry = time.Hour * 24
+
+var errInvalidAmount = errors.New("paylinks: invalid amount")
+
+// conflict_retries is how often a link is reloaded when another request
+// changed it between being read and being written.
+var conflict_retries = 5
+
+// NewPayLinks serves the public /pay/{slug} pages. Submitting the form creates
+// the customer if needed and a fresh invoice, then redirects to the processor.
+// The processor sends the customer back to the checkout return page when
+// checkout is set, and to the callback configured with the processor
+// otherwise.
+func NewPayLinks(branding documents.Branding, links *checkout.Links) *billing.Billing {
+	branding = branding.WithDefaults()
+	callback := ""
+	if links != nil {
+		callback = links.ReturnURL()
+	}
+	fail := func(w http.ResponseWriter, status int, message string) {
+		v := newView(branding, nil)
+		v.Error = message
+		v.render(w, status)
+	}
+
+	return &billing.Billing{
+		Name: "pay",
+		Start: func(c context.Context, ctx *billing.BillingContext) error {
+			for _, t := range []events.Type{events.InvoicePaid, events.InvoiceExpired, events.InvoiceCancelled, events.InvoiceUncollectible} {
+				ctx.Events.Subscribe(t, freeSlot(ctx))
+			}
+			return nil
+		},
+		Init: func(r chi.Router, ctx *billing.BillingContext) {
+			r.Get("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+				link, err := ctx.Links.Query(database.WithFilter("slug", r.PathValue("slug"))).First()
+				if err != nil {
+					fail(w, http.StatusNotFound, "This payment link doesn't exist.")
+					return
+				}
+				if message := closed(link, ctx.Clock.Now()); message != "" {
+					fail(w, http.StatusGone, message)
+					return
+				}
+				newView(branding, link).render(w, http.StatusOK)
+			})
+			r.Post("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+				link, err := ctx.Links.Query(database.WithFilter("slug", r.PathValue("slug"))).First()
+				if err != nil {
+					fail(w, http.StatusNotFound, "This payment link doesn't exist.")
+					return
+				}
+				if message := closed(link, ctx.Clock.Now()); message != "" {
+					fail(w, http.StatusGone, message)
+					return
+				}
+				if err := r.ParseForm(); err != nil {
+					fail(w, http.StatusBadRequest, "We couldn't read your details, please try again.")
+					return
+				}
+
+				v := newView(branding, link)
+				v.Email = strings.TrimSpace(r.PostForm.Get("email"))
+				v.FirstName = strings.TrimSpace(r.PostForm.Get("first_name"))
+				v.LastName = strings.TrimSpace(r.PostForm.Get("last_name"))
+				v.Entered = strings.TrimSpace(r.PostForm.Get("amount"))
+				if _, err := mail.ParseAddress(v.Email); err != nil || v.FirstName == "" {
+					v.Error = "Please enter your email address and name."
+					v.render(w, http.StatusBadRequest)
+					return
+				}
+				amount := link.Amount
+				if amount == 0 {
+					if amount, err = parseAmount(v.Entered); err != nil || amount < max(link.MinAmount, 1) {
+						v.Error = "Please enter a valid amount."
+						if link.MinAmount > 0 {
+							v.Error = "Please enter an amount of at least " + branding.Money(link.MinAmount) + "."
+						}
+						v.render(w, http.StatusBadRequest)
+						return
+					}
+				}
+
+				customer, err := ctx.Customer.Query(database.WithFilter("email", v.Email)).First()
+				if err != nil {
+					customer = &models.Customer{
+						ID:        uuid.New(),
+						Email:     v.Email,
+						FirstName: v.FirstName,
+						LastName:  v.LastName,
+						CreatedAt: ctx.Clock.Now(),
+					}
+					if err := ctx.Customer.Save(*customer); err != nil {
+						fail(w, http.StatusInternalServerError, "We couldn't save your details, please try again.")
+						return
+					}
+				}
+
+				now := ctx.Clock.Now()
+				invoice := &models.Invoice{
+					ID:           uuid.New(),
+					CustomerID:   customer.ID,
+					LinkID:       link.ID,
+					Amount:       amount,
+					Description:  link.Description,
+					Status:       models.InvIssued,
+					AttemptCount: 1,
+					LastAttempt:  now,
+					ExpiresAt:    now.Add(expiry),
+					CreatedAt:    now,
+				}
+				if err := ctx.BeforeCharge(r.Context(), invoice, customer); err != nil {
+					v.Error = err.Error()
+					v.render(w, http.StatusBadRequest)
+					return
+				}
+				// the invoice holds a slot of the link's usage limit until it is paid or closed
+				var message string
+				if _, err := change(ctx, link.ID, func(link *models.PaymentLink) bool {
+					if message = closed(link, now); message != "" {
+						return false
+					}
+					link.Uses += 1
+					link.Open += 1
+					return true
+				}); err != nil {
+					fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+					return
+				}
+				if message != "" {
+					fail(w, http.StatusGone, message)
+					return
+				}
+
+				trx := models.NewTransaction(invoice.ID, ctx.NewReference(), now)
+				unit := ctx.Unit(r.Context())
+				billing.NumberIn(unit, ctx.Numbering, invoice, invoice.CreatedAt)
+				billing.SaveIn(unit, ctx.Invoice, invoice)
+				billing.SaveIn(unit, ctx.Transactions, trx)
+				if err := unit.Commit(); err != nil {
+					if _, err := change(ctx, link.ID, func(link *models.PaymentLink) bool {
+						link.Uses -= 1
+						link.Open -= 1
+						return true
+					}); err != nil {
+						log.Printf("paylinks: releasing a slot of link %s: %v", link.ID, err)
+					}
+					fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+					return
+				}
+				ctx.Publish(r.Context(), events.Event{Type: events.InvoiceIssued, Customer: customer, Invoice: invoice, Transaction: trx})
+
+				auth_url, err := ctx.Processor.Init(r.Context(), customer.Email, invoice.Amount, trx.Reference, callback)
+				if err != nil {
+					// nothing was opened with the processor, the customer starts over
+					// with a new invoice and this one gives its slot back
+					if err := cancel(r.Context(), ctx, customer, invoice, trx); err != nil {
+						log.Printf("paylinks: cancelling invoice %s: %v", invoice.ID, err)
+					}
+					fail(w, http.StatusBadGateway, "We couldn't reach the payment provider, please try again.")
+					return
+				}
+				trx.AuthorizationURL = auth_url
+				if err := billing.Update(ctx.Transactions, trx); err != nil {
+					fail(w, http.StatusInternalServerError, "We couldn't start your payment, please try again.")
+					return
+				}
+				http.Redirect(w, r, auth_url, http.StatusSeeOther)
+			})
+		},
+	}
+}
+
+// closed returns why link can't be used any more, or "" when it can.
+func closed(link *models.PaymentLink, now time.Time) string {
+	if !link.Active {
+		return "This payment link is no longer active."
+	}
+	if !link.ExpiresAt.IsZero() && now.After(link.ExpiresAt) {
+		return "This payment link has expired."
+	}
+	if link.UsageLimit > 0 && link.Open+link.Conversions >= link.UsageLimit {
+		return "This payment link has reached its limit."
+	}
+	return ""
+}
+
+// change applies fn to the stored link and writes it back, reloading and
+// applying it again when another request changed the link in between. fn
+// returns false to leave the link as it is.
+func change(ctx *billing.BillingContext, id uuid.UUID, fn func(link *models.PaymentLink) bool) (*models.PaymentLink, error) {
+	for attempt := 0; ; attempt++ {
+		link, err := ctx.Links.Query(database.WithFilter("id", id)).First()
+		if err != nil {
+			return nil, err
+		}
+		if !fn(link) {
+			return link, nil
+		}
+		err = billing.Update(ctx.Links, link)
+		if !errors.Is(err, billing.ErrConflict) || attempt == conflict_retries {
+			return link, err
+		}
+	}
+}
+
+// freeSlot gives back the slot an invoice opened through a link held once
+// the invoice leaves ISSUED, and counts the conversion when it is paid. An
+// invoice paid after it expired gave its slot back already.
+func freeSlot(ctx *billing.BillingContext) events.Handler {
+	return func(c context.Context, e events.Event) error {
+		invoice := e.Invoice
+		if invoice == nil || invoice.LinkID == uuid.Nil || len(invoice.History) == 0 {
+			return nil
+		}
+		issued := invoice.History[len(invoice.History)-1].From == models.InvIssued
+		paid := invoice.Status == models.InvPaid
+		_, err := change(ctx, invoice.LinkID, func(link *models.PaymentLink) bool {
+			if issued {
+				link.Open -= 1
+			}
+			if paid {
+				link.Conversions += 1
+			}
+			return issued || paid
+		})
+		return err
+	}
+}
+
+// cancel closes an invoice whose payment couldn't be started.
+func cancel(c context.Context, ctx *billing.BillingContext, customer *models.Customer, invoice *models.Invoice, trx *models.Transaction) error {
+	trx.SetStatus(models.TrxFailed, ctx.Clock.Now())
+	if err := invoice.Transition(models.InvCancelled, "system", "payment provider unavailable", ctx.Clock.Now()); err != nil {
+		return err
+	}
+	unit := ctx.Unit(c)
+	billing.UpdateIn(unit, ctx.Transactions, trx)
+	billing.UpdateIn(unit, ctx.Invoice, invoice)
+	if err := unit.Commit(); err != nil {
+		return err
+	}
+	ctx.Publish(c, events.Event{Type: events.InvoiceCancelled, Customer: customer, Invoice: invoice})
+	return nil
+}
+
+// parseAmount reads an amount in major units, e.g. "1,250.50", into minor units.
+func parseAmount(s string) (int64, error) {
+	s = strings.ReplaceAll(s, ",", "")
+	units, cents, _ := strings.Cut(s, ".")
+	if units == "" || len(cents) > 2 {
+		return 0, errInvalidAmount
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+	major, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || major < 0 {
+		return 0, errInvalidAmount
+	}
+	minor, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil || minor < 0 {
+		return 0, errInvalidAmount
+	}
+	return major*100 + minor, nil
+}
@@ -0,0 +1,213 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/payments/processors"
+)
+
+type Option func(*Poller)
+
+// Poller re-verifies pending transactions with the processor, so payments
+// that complete after the customer has left, such as bank transfers, are
+// still settled. Each transaction is checked less often the longer it stays
+// pending, and abandoned once it is older than the max age.
+type Poller struct {
+	interval    time.Duration
+	spacing     time.Duration
+	max_spacing time.Duration
+	max_age     time.Duration
+	// at most one Verify call per rate, across the whole pass
+	rate  time.Duration
+	batch int
+}
+
+// WithInterval sets how often the poller looks for transactions due a check.
+func WithInterval(interval time.Duration) Option {
+	return func(p *Poller) {
+		p.interval = interval
+	}
+}
+
+// WithSpacing sets the wait before the first check, doubled after every
+// check that finds the transaction still pending, up to max.
+func WithSpacing(spacing, max time.Duration) Option {
+	return func(p *Poller) {
+		p.spacing = spacing
+		p.max_spacing = max
+	}
+}
+
+// WithMaxAge sets how long a transaction may stay pending before it is abandoned.
+func WithMaxAge(age time.Duration) Option {
+	return func(p *Poller) {
+		p.max_age = age
+	}
+}
+
+// WithRateLimit caps Verify calls to perSecond.
+func WithRateLimit(perSecond int) Option {
+	return func(p *Poller) {
+		if perSecond > 0 {
+			p.rate = time.Second / time.Duration(perSecond)
+		}
+	}
+}
+
+// WithBatch sets how many due transactions a pass loads at a time, 100 by default.
+func WithBatch(batch int) Option {
+	return func(p *Poller) {
+		p.batch = batch
+	}
+}
+
+func New(opts ...Option) *Poller {
+	cfg := &Poller{
+		interval:    time.Minute,
+		spacing:     time.Minute,
+		max_spacing: time.Hour * 6,
+		max_age:     time.Hour * 72,
+		rate:        time.Second / 5,
+		batch:       100,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.batch <= 0 {
+		cfg.batch = 100
+	}
+	return cfg
+}
+
+// Billing runs the poller as a background module.
+func (p *Poller) Billing() *billing.Billing {
+	return (&billing.Billing{Name: "poller"}).Every(p.interval, p.RunOnce)
+}
+
+// RunOnce checks every pending transaction that is due, a batch at a time,
+// longest overdue first. A transaction never checked has a zero NextVerify
+// and is due straight away. Each batch is one query, and the next one starts
+// past the last transaction seen. It stops early when the processor says it
+// is being called too often.
+func (p *Poller) RunOnce(ctx context.Context, bctx *billing.BillingContext) error {
+	now := bctx.Clock.Now()
+	var (
+		last   time.Time
+		marker *models.Transaction
+	)
+	for {
+		filter := billing.Filter{"status": models.TrxPending}.Where("next_verify", "$lte", now)
+		if marker != nil {
+			filter.Or(
+				billing.Filter{}.Where("next_verify", "$gt", marker.NextVerify),
+				billing.Filter{"next_verify": marker.NextVerify}.Where("id", "$gt", marker.ID),
+			)
+		}
+		opts := append(filter.Options(),
+			billing.Sort("next_verify", false),
+			billing.Sort("id", false),
+			database.WithLimit(int64(p.batch)),
+		)
+		due, err := bctx.Transactions.Query(opts...).All()
+		if err != nil {
+			return err
+		}
+		for _, trx := range due {
+			// pace calls in real time, whatever clock billing runs on
+			if wait := p.rate - time.Since(last); !last.IsZero() && wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+			last = time.Now()
+
+			err := p.poll(ctx, bctx, trx)
+			if errors.Is(err, processors.ErrRateLimited) {
+				return err
+			}
+			if err != nil {
+				log.Printf("poller: transaction %s: %v", trx.ID, err)
+			}
+		}
+		if len(due) < p.batch {
+			return nil
+		}
+		marker = due[len(due)-1]
+	}
+}
+
+func (p *Poller) poll(ctx context.Context, bctx *billing.BillingContext, trx *models.Transaction) error {
+	ctx, release, err := bctx.LockInvoice(ctx, trx.InvoiceID)
+	if errors.Is(err, lease.ErrHeld) {
+		// someone is already settling it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if trx, err = bctx.Transactions.Query(database.WithFilter("id", trx.ID)).First(); err != nil {
+		return err
+	}
+	if trx.Status != models.TrxPending {
+		return nil
+	}
+	invoice, err := bctx.Invoice.Query(database.WithFilter("id", trx.InvoiceID)).First()
+	if err != nil {
+		return err
+	}
+	now := bctx.Clock.Now()
+	res, err := bctx.Processor.Verify(ctx, trx.Reference)
+	if err != nil {
+		if errors.Is(err, processors.ErrRateLimited) {
+			return err
+		}
+		if lerr := p.later(bctx, trx, now); lerr != nil {
+			return lerr
+		}
+		return err
+	}
+	switch res {
+	case processors.Reversed:
+		res = processors.Failed
+	case processors.Pending, 0:
+		if now.Sub(trx.CreatedAt) <= p.max_age {
+			return p.later(bctx, trx, now)
+		}
+		res = processors.Abandoned
+	}
+	// the invoice may have been paid, voided or cancelled since, Settle then
+	// keeps a verified success on the transaction and flags it for refund
+	customer, err := bctx.CustomerOf(invoice.CustomerID)
+	if err != nil {
+		return err
+	}
+	if err := bctx.Settle(ctx, customer, invoice, trx, res); err != nil {
+		return err
+	}
+	if trx.RefundDue {
+		log.Printf("poller: transaction %s paid invoice %s in status %s, refund due", trx.ID, invoice.ID, invoice.Status)
+	}
+	return nil
+}
+
+// later schedules the next check, twice as far out as the last one.
+func (p *Poller) later(bctx *billing.BillingContext, trx *models.Transaction, now time.Time) error {
+	spacing := p.spacing
+	for i := int64(0); i < trx.VerifyAttempts && spacing < p.max_spacing; i++ {
+		spacing *= 2
+	}
+	trx.VerifyAttempts += 1
+	trx.NextVerify = now.Add(min(spacing, p.max_spacing))
+	return billing.Update(bctx.Transactions, trx)
+}
@@ -0,0 +1,122 @@
+package sweeper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+)
+
+// Sweeper expires issued invoices once they are past ExpiresAt, so they don't
+// linger as ISSUED until someone tries to pay them.
+type Sweeper struct {
+	interval time.Duration
+	batch    int
+}
+
+func New(interval time.Duration, batch int) *Sweeper {
+	if batch <= 0 {
+		batch = 100
+	}
+	return &Sweeper{
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+// Billing runs the sweeper as a background module.
+func (s *Sweeper) Billing() *billing.Billing {
+	return (&billing.Billing{Name: "sweeper"}).Every(s.interval, s.RunOnce)
+}
+
+// RunOnce expires every overdue invoice, a batch at a time. Each batch is
+// one query for at most batch invoices, ordered by deadline, and the next one
+// starts past the last invoice seen, so those left ISSUED are not met again.
+func (s *Sweeper) RunOnce(ctx context.Context, bctx *billing.BillingContext) error {
+	now := bctx.Clock.Now()
+	var last *models.Invoice
+	for {
+		filter := billing.Filter{"status": models.InvIssued}.Where("expires_at", "$lt", now)
+		if last != nil {
+			filter.Or(
+				billing.Filter{}.Where("expires_at", "$gt", last.ExpiresAt),
+				billing.Filter{"expires_at": last.ExpiresAt}.Where("id", "$gt", last.ID),
+			)
+		}
+		opts := append(filter.Options(),
+			billing.Sort("expires_at", false),
+			billing.Sort("id", false),
+			database.WithLimit(int64(s.batch)),
+		)
+		overdue, err := bctx.Invoice.Query(opts...).All()
+		if err != nil {
+			return err
+		}
+		for _, invoice := range overdue {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := s.expire(ctx, bctx, invoice); err != nil {
+				log.Printf("sweeper: invoice %s: %v", invoice.ID, err)
+			}
+		}
+		if len(overdue) < s.batch {
+			return nil
+		}
+		last = overdue[len(overdue)-1]
+	}
+}
+
+func (s *Sweeper) expire(ctx context.Context, bctx *billing.BillingContext, invoice *models.Invoice) error {
+	ctx, release, err := bctx.LockInvoice(ctx, invoice.ID)
+	if errors.Is(err, lease.ErrHeld) {
+		// someone is paying or changing it right now, the next sweep sees the outcome
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	if invoice, err = bctx.Invoice.Query(database.WithFilter("id", invoice.ID)).First(); err != nil {
+		return err
+	}
+	if invoice.Status != models.InvIssued || !bctx.Clock.Now().After(invoice.ExpiresAt) {
+		return nil
+	}
+
+	trx, err := bctx.LatestTransaction(invoice.ID)
+	if err != nil {
+		return err
+	}
+	customer, err := bctx.CustomerOf(invoice.CustomerID)
+	if err != nil {
+		return err
+	}
+	if trx != nil && trx.Status == models.TrxPending {
+		// don't lose a payment that landed after the deadline
+		res, err := bctx.Processor.Verify(ctx, trx.Reference)
+		if err != nil {
+			return err
+		}
+		if err := bctx.Settle(ctx, customer, invoice, trx, res); err != nil {
+			return err
+		}
+		if invoice.Status == models.InvPaid {
+			return nil
+		}
+	}
+	if err := invoice.Transition(models.InvExpired, "sweeper", "invoice expired", bctx.Clock.Now()); err != nil {
+		return err
+	}
+	if err := billing.Update(bctx.Invoice, invoice); err != nil {
+		return err
+	}
+	bctx.Publish(ctx, events.Event{Type: events.InvoiceExpired, Customer: customer, Invoice: invoice})
+	return nil
+}
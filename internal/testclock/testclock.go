@@ -0,0 +1,69 @@
+package testclock
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/utilities"
+)
+
+// NewTestClock lets integration tests move c forward over the API. After
+// every advance each module's background work runs once, in registration
+// order, before the response is sent.
+func NewTestClock(c *clock.TestClock, modules []*billing.Billing) *billing.Billing {
+	return &billing.Billing{
+		Name: "clock",
+		Init: func(r chi.Router, ctx *billing.BillingContext) {
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				utilities.JSON(w).SetStatusCode(http.StatusOK).SetStatus(utilities.ResponseSuccess).
+					SetData(map[string]time.Time{"now": c.Now()}).Send()
+			})
+			r.Post("/advance", func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					// Duration is parsed by time.ParseDuration, e.g. "36h".
+					Duration string    `json:"duration"`
+					To       time.Time `json:"to"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					utilities.JSON(w).SetStatus(utilities.ResponseError).
+						SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+					return
+				}
+				switch {
+				case body.Duration != "":
+					d, err := time.ParseDuration(body.Duration)
+					if err != nil || d < 0 {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).SetMessage("duration must be a positive Go duration, e.g. 36h").Send()
+						return
+					}
+					c.Advance(d)
+				case !body.To.IsZero():
+					if body.To.Before(c.Now()) {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).SetMessage("the clock can't go backwards").Send()
+						return
+					}
+					c.Set(body.To)
+				default:
+					utilities.JSON(w).SetStatus(utilities.ResponseFail).
+						SetStatusCode(http.StatusBadRequest).SetMessage("duration or to is required").Send()
+					return
+				}
+
+				errs := make(map[string]string)
+				for _, b := range modules {
+					if err := b.Pass(r.Context(), ctx); err != nil {
+						errs[b.Name] = err.Error()
+					}
+				}
+				utilities.JSON(w).SetStatusCode(http.StatusOK).SetStatus(utilities.ResponseSuccess).
+					SetData(map[string]any{"now": c.Now(), "errors": errs}).Send()
+			})
+		},
+	}
+}
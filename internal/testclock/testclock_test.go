@@ -0,0 +1,57 @@
+package testclock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/clock"
+)
+
+func TestAdvanceRunsEveryModuleOnce(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewTestClock(start)
+	bctx := &billing.BillingContext{Clock: c}
+	var order []string
+	var seen []time.Time
+	module := func(name string) *billing.Billing {
+		return (&billing.Billing{Name: name}).Every(time.Millisecond, func(ctx context.Context, bctx *billing.BillingContext) error {
+			order = append(order, name)
+			seen = append(seen, bctx.Clock.Now())
+			return nil
+		})
+	}
+	modules := []*billing.Billing{module("sweeper"), module("dunning")}
+	for _, b := range modules {
+		if err := b.Start(context.Background(), bctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := chi.NewRouter()
+	NewTestClock(c, modules).Init(r, bctx)
+
+	time.Sleep(20 * time.Millisecond)
+	if len(order) != 0 {
+		t.Fatalf("modules ticked on their own under a test clock: %v", order)
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/advance", strings.NewReader(`{"duration":"36h"}`)))
+	if strings.Join(order, ",") != "sweeper,dunning" {
+		t.Fatalf("got passes %v, want each module once in order", order)
+	}
+	for _, at := range seen {
+		if !at.Equal(start.Add(36 * time.Hour)) {
+			t.Errorf("a pass saw %v, want the advanced time", at)
+		}
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/advance", strings.NewReader(`{"to":"2025-01-01T00:00:00Z"}`)))
+	if len(order) != 2 || !c.Now().Equal(start.Add(36*time.Hour)) {
+		t.Fatalf("moving the clock back ran %d passes and left it at %v", len(order)-2, c.Now())
+	}
+}
@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/utils"
+	"github.com/neghi-go/utilities"
+)
+
+// Billing exposes endpoint registration and the delivery log, and retries
+// failed deliveries in the background.
+func (d *Dispatcher) Billing() *billing.Billing {
+	b := &billing.Billing{
+		Name:        "webhooks",
+		Collections: []billing.Collection{d.endpoints, d.deliveries},
+		Init: func(r chi.Router, ctx *billing.BillingContext) {
+			d.clock = ctx.Clock
+			ctx.Events.SubscribeAsync(events.All, d.Handle)
+			r.Route("/endpoints", func(r chi.Router) {
+				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+					endpoints, err := d.endpoints.Model().Query().All()
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					utilities.JSON(w).SetStatusCode(http.StatusOK).
+						SetStatus(utilities.ResponseSuccess).SetData(endpoints).Send()
+				})
+				r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+					var body struct {
+						URL    string   `json:"url"`
+						Events []string `json:"events"`
+					}
+
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					if u, err := url.Parse(body.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).SetMessage("url must be an absolute http(s) URL").Send()
+						return
+					}
+					for _, e := range body.Events {
+						if !known(e) {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage("unknown event: " + e).Send()
+							return
+						}
+					}
+
+					endpoint := models.WebhookEndpoint{
+						ID:        uuid.New(),
+						URL:       body.URL,
+						Secret:    "whsec_" + utils.GenerateReference(24),
+						Events:    body.Events,
+						Active:    true,
+						CreatedAt: ctx.Clock.Now(),
+					}
+					if err := d.endpoints.Model().Save(endpoint); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					// the secret is only ever shown here
+					utilities.JSON(w).SetStatusCode(http.StatusCreated).
+						SetStatus(utilities.ResponseSuccess).SetData(struct {
+						models.WebhookEndpoint
+						Secret string `json:"secret"`
+					}{endpoint, endpoint.Secret}).Send()
+				})
+				r.Delete("/{endpoint_id}", func(w http.ResponseWriter, r *http.Request) {
+					id, err := uuid.Parse(r.PathValue("endpoint_id"))
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					endpoint, err := d.endpoints.Model().Query(database.WithFilter("id", id)).First()
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseFail).
+							SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+						return
+					}
+					// deliveries keep pointing at it, so deactivate instead of deleting
+					endpoint.Active = false
+					if err := d.endpoints.Model().Query(database.WithFilter("id", id)).Update(*endpoint); err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				})
+			})
+			r.Route("/deliveries", func(r chi.Router) {
+				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+					opts := make([]database.Options, 0)
+					if id := r.URL.Query().Get("endpoint_id"); id != "" {
+						endpointID, err := uuid.Parse(id)
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						opts = append(opts, database.WithFilter("endpoint_id", endpointID))
+					}
+					if status := r.URL.Query().Get("status"); status != "" {
+						opts = append(opts, database.WithFilter("status", status))
+					}
+					deliveries, err := d.deliveries.Model().Query(opts...).All()
+					if err != nil {
+						utilities.JSON(w).SetStatus(utilities.ResponseError).
+							SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+						return
+					}
+					utilities.JSON(w).SetStatusCode(http.StatusOK).
+						SetStatus(utilities.ResponseSuccess).SetData(deliveries).Send()
+				})
+				r.Route("/{delivery_id}", func(r chi.Router) {
+					r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+						id, err := uuid.Parse(r.PathValue("delivery_id"))
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						delivery, err := d.deliveries.Model().Query(database.WithFilter("id", id)).First()
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+							return
+						}
+						utilities.JSON(w).SetStatusCode(http.StatusOK).
+							SetStatus(utilities.ResponseSuccess).SetData(delivery).Send()
+					})
+					r.Post("/resend", func(w http.ResponseWriter, r *http.Request) {
+						id, err := uuid.Parse(r.PathValue("delivery_id"))
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusBadRequest).SetMessage(err.Error()).Send()
+							return
+						}
+						delivery, err := d.deliveries.Model().Query(database.WithFilter("id", id)).First()
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+							return
+						}
+						endpoint, err := d.endpoints.Model().Query(database.WithFilter("id", delivery.EndpointID)).First()
+						if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusNotFound).SetMessage(err.Error()).Send()
+							return
+						}
+						if err := d.Deliver(r.Context(), endpoint, delivery); errors.Is(err, ErrClaimed) {
+							utilities.JSON(w).SetStatus(utilities.ResponseFail).
+								SetStatusCode(http.StatusConflict).SetMessage("Delivery is already being sent").Send()
+							return
+						} else if err != nil {
+							utilities.JSON(w).SetStatus(utilities.ResponseError).
+								SetStatusCode(http.StatusInternalServerError).SetMessage(err.Error()).Send()
+							return
+						}
+						utilities.JSON(w).SetStatusCode(http.StatusOK).
+							SetStatus(utilities.ResponseSuccess).SetData(delivery).Send()
+					})
+				})
+			})
+		},
+	}
+	return b.Every(d.interval, func(ctx context.Context, _ *billing.BillingContext) error {
+		return d.RunOnce(ctx)
+	})
+}
+
+func known(e string) bool {
+	if e == string(events.All) {
+		return true
+	}
+	for _, t := range events.Types() {
+		if e == string(t) {
+			return true
+		}
+	}
+	return false
+}
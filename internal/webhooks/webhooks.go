@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var (
+	SignatureHeader = "X-Payments-Signature"
+	EventHeader     = "X-Payments-Event"
+	DeliveryHeader  = "X-Payments-Delivery"
+
+	response_limit int64 = 4096
+)
+
+type Option func(*Dispatcher)
+
+// Dispatcher fans published events out to registered endpoints, persisting
+// every delivery and retrying failures with exponential backoff.
+type Dispatcher struct {
+	endpoints    *billing.Handle[models.WebhookEndpoint]
+	deliveries   *billing.Handle[models.WebhookDelivery]
+	client       *http.Client
+	max_attempts int
+	backoff      time.Duration
+	max_backoff  time.Duration
+	interval     time.Duration
+	clock        clock.Clock
+}
+
+func WithClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetries sets how many times a delivery is attempted and the delay before
+// the first retry, which doubles on every further attempt.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.max_attempts = attempts
+		d.backoff = backoff
+	}
+}
+
+// WithInterval sets how often due retries are picked up.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.interval = interval
+	}
+}
+
+// New declares the webhook_endpoints and webhook_deliveries collections,
+// which are bound once the module returned by Billing is built.
+func New(opts ...Option) *Dispatcher {
+	cfg := &Dispatcher{
+		endpoints:    billing.Declare[models.WebhookEndpoint]("webhook_endpoints"),
+		deliveries:   billing.Declare[models.WebhookDelivery]("webhook_deliveries"),
+		client:       &http.Client{Timeout: time.Second * 10},
+		max_attempts: 8,
+		backoff:      time.Minute,
+		max_backoff:  time.Hour * 24,
+		interval:     time.Minute,
+		clock:        clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// Sign returns the signature header value for payload sent at t. Receivers
+// recompute the HMAC-SHA256 of "<t>.<payload>" with the endpoint secret.
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Handle records a delivery for every active endpoint subscribed to e and
+// makes the first attempt.
+func (d *Dispatcher) Handle(ctx context.Context, e events.Event) error {
+	endpoints, err := d.endpoints.Model().Query(database.WithFilter("active", true)).All()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		if !subscribed(endpoint, e.Type) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			ID:         uuid.New(),
+			EndpointID: endpoint.ID,
+			EventID:    e.ID,
+			Event:      string(e.Type),
+			Payload:    string(payload),
+			Status:     models.DeliveryPending,
+			Attempts:   make([]*models.WebhookAttempt, 0),
+			CreatedAt:  d.clock.Now(),
+		}
+		if err := d.deliveries.Model().Save(*delivery); err != nil {
+			return err
+		}
+		if err := d.Deliver(ctx, endpoint, delivery); err != nil {
+			log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+		}
+	}
+	return nil
+}
+
+func subscribed(endpoint *models.WebhookEndpoint, t events.Type) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, e := range endpoint.Events {
+		if e == string(t) || e == string(events.All) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrClaimed is returned by Deliver when another instance is already sending
+// the delivery.
+var ErrClaimed = errors.New("webhooks: delivery claimed by another instance")
+
+// claim takes the delivery for one attempt, only while it is still the copy
+// read, and pushes its next attempt past the time the attempt may take, so
+// other instances leave it alone and pick it up again if this one dies.
+func (d *Dispatcher) claim(delivery *models.WebhookDelivery) error {
+	window := time.Minute
+	if d.client.Timeout > 0 {
+		window = 2 * d.client.Timeout
+	}
+	claimed := *delivery
+	claimed.Token = uuid.NewString()
+	claimed.NextAttempt = d.clock.Now().Add(window)
+	held := billing.Filter{"id": delivery.ID, "token": delivery.Token}
+	if delivery.Token == "" {
+		// deliveries stored before tokens existed have no token field
+		held.Where("token", "$in", []any{nil, ""})
+	}
+	err := d.deliveries.Model().Query(held.Options()...).Update(claimed)
+	if err != nil {
+		return err
+	}
+	stored, err := d.deliveries.Model().Query(database.WithFilter("id", delivery.ID)).First()
+	if err != nil {
+		return err
+	}
+	if stored.Token != claimed.Token {
+		return ErrClaimed
+	}
+	*delivery = claimed
+	return nil
+}
+
+// Deliver claims the delivery, makes one attempt and stores its outcome.
+func (d *Dispatcher) Deliver(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) error {
+	if err := d.claim(delivery); err != nil {
+		return err
+	}
+	now := d.clock.Now()
+	attempt := &models.WebhookAttempt{At: now}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(EventHeader, delivery.Event)
+		req.Header.Add(DeliveryHeader, delivery.ID.String())
+		req.Header.Add(SignatureHeader, Sign(endpoint.Secret, now, []byte(delivery.Payload)))
+
+		res, err := d.client.Do(req)
+		if err != nil {
+			attempt.Error = err.Error()
+		} else {
+			body, _ := io.ReadAll(io.LimitReader(res.Body, response_limit))
+			res.Body.Close()
+			attempt.StatusCode = res.StatusCode
+			attempt.Response = string(body)
+		}
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	switch {
+	case attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		delivery.Status = models.DeliverySucceeded
+		delivery.NextAttempt = time.Time{}
+	case len(delivery.Attempts) >= d.max_attempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.NextAttempt = time.Time{}
+	default:
+		delivery.Status = models.DeliveryPending
+		delivery.NextAttempt = now.Add(d.delay(len(delivery.Attempts)))
+	}
+	return d.deliveries.Model().Query(
+		database.WithFilter("id", delivery.ID),
+		database.WithFilter("token", delivery.Token),
+	).Update(*delivery)
+}
+
+func (d *Dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.max_backoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.max_backoff)
+}
+
+// RunOnce retries every pending delivery whose next attempt is due. Each is
+// claimed before it is sent, so an instance running alongside skips it.
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	due := billing.Filter{"status": models.DeliveryPending}.
+		Where("next_attempt", "$gt", time.Time{}).
+		Where("next_attempt", "$lte", d.clock.Now())
+	deliveries, err := d.deliveries.Model().Query(due.Options()...).All()
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		endpoint, err := d.endpoints.Model().Query(database.WithFilter("id", delivery.EndpointID)).First()
+		if err != nil {
+			log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+			continue
+		}
+		if !endpoint.Active {
+			continue
+		}
+		if err := d.Deliver(ctx, endpoint, delivery); err != nil && !errors.Is(err, ErrClaimed) {
+			log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+var (
+	ErrHeld = errors.New("lease: held by another owner")
+	// ErrLost is returned by renew once the lease expired and was taken over.
+	ErrLost = errors.New("lease: no longer held")
+)
+
+// store is the part of the leases collection Leases works with.
+type store interface {
+	insert(l models.Lease) error
+	get(id string) (*models.Lease, error)
+	// replace writes l only while old's owner and expiry are still stored.
+	replace(old, l models.Lease) error
+	// remove deletes the lease only while owner holds it.
+	remove(id, owner string) error
+}
+
+type modelStore struct {
+	model database.Model[models.Lease]
+}
+
+func (s modelStore) insert(l models.Lease) error { return s.model.Save(l) }
+
+func (s modelStore) get(id string) (*models.Lease, error) {
+	return s.model.Query(database.WithFilter("id", id)).First()
+}
+
+func (s modelStore) replace(old, l models.Lease) error {
+	return s.model.Query(
+		database.WithFilter("id", old.ID),
+		database.WithFilter("owner", old.Owner),
+		database.WithFilter("expires_at", old.ExpiresAt),
+	).Update(l)
+}
+
+func (s modelStore) remove(id, owner string) error {
+	return s.model.Query(database.WithFilter("id", id), database.WithFilter("owner", owner)).Delete()
+}
+
+// Leases hands out mutually exclusive, expiring leases stored in a
+// collection, so they hold across every instance sharing the database. A
+// lease whose holder died is taken over once its TTL has passed, while a live
+// holder keeps extending it until it releases the lease.
+type Leases struct {
+	store store
+	clock clock.Clock
+	ttl   time.Duration
+}
+
+func New(leases database.Model[models.Lease], c clock.Clock, ttl time.Duration) *Leases {
+	return &Leases{
+		store: modelStore{model: leases},
+		clock: c,
+		ttl:   ttl,
+	}
+}
+
+// Acquire takes the lease on id, returning ErrHeld while someone else has
+// it. The lease is renewed every third of its TTL on the leases' clock, so
+// long processor calls stay covered, until release is called. held is ctx
+// for as long as the lease is held, it is cancelled when the lease is lost
+// or released, so the work it protects stops with it.
+func (l *Leases) Acquire(ctx context.Context, id string) (held context.Context, release func(), err error) {
+	owner, err := l.take(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	held, cancel := context.WithCancel(ctx)
+
+	var (
+		mu       sync.Mutex
+		released bool
+		stop     func() bool
+		renew    func()
+	)
+	renew = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if released {
+			return
+		}
+		if err := l.renew(id, owner); err != nil {
+			log.Printf("lease: renewing %s: %v", id, err)
+			if errors.Is(err, ErrLost) {
+				cancel()
+				return
+			}
+		}
+		stop = l.clock.AfterFunc(l.ttl/3, renew)
+	}
+	stop = l.clock.AfterFunc(l.ttl/3, renew)
+
+	return held, func() {
+		mu.Lock()
+		released = true
+		stop()
+		mu.Unlock()
+		cancel()
+		if err := l.store.remove(id, owner); err != nil {
+			// the lease lapses on its own once its TTL has passed
+			log.Printf("lease: releasing %s: %v", id, err)
+		}
+	}, nil
+}
+
+// take claims the lease for a new owner. The unique id lets exactly one
+// concurrent insert through, and an expired lease is taken over with a write
+// conditioned on the holder it expired under, so only one taker wins.
+func (l *Leases) take(id string) (owner string, err error) {
+	owner = uuid.NewString()
+	now := l.clock.Now()
+	lease := models.Lease{ID: id, Owner: owner, ExpiresAt: now.Add(l.ttl), Token: uuid.NewString()}
+	if err := l.store.insert(lease); err != nil {
+		held, qerr := l.store.get(id)
+		if qerr != nil {
+			return "", err
+		}
+		if held.ExpiresAt.After(now) {
+			return "", ErrHeld
+		}
+		if err := l.store.replace(*held, lease); err != nil {
+			return "", err
+		}
+		// the database doesn't say whether the condition matched, the token does
+		if held, err := l.store.get(id); err != nil || held.Token != lease.Token {
+			return "", ErrHeld
+		}
+	}
+	return owner, nil
+}
+
+// renew extends owner's lease on id by the TTL from now. It returns ErrLost
+// when the lease was taken over, the work it protects is no longer exclusive.
+func (l *Leases) renew(id, owner string) error {
+	held, err := l.store.get(id)
+	if err != nil {
+		return err
+	}
+	if held.Owner != owner {
+		return ErrLost
+	}
+	renewed := models.Lease{ID: id, Owner: owner, ExpiresAt: l.clock.Now().Add(l.ttl), Token: uuid.NewString()}
+	if err := l.store.replace(*held, renewed); err != nil {
+		return err
+	}
+	if held, err := l.store.get(id); err != nil || held.Token != renewed.Token {
+		return ErrLost
+	}
+	return nil
+}
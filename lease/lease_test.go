@@ -0,0 +1,204 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/internal/models"
+)
+
+// memStore keeps leases in a map, with the same conditional writes as the
+// collection. race, when set, runs before a replace, standing in for a
+// concurrent writer.
+type memStore struct {
+	mu     sync.Mutex
+	leases map[string]models.Lease
+	race   func(leases map[string]models.Lease)
+}
+
+func newMemStore() *memStore {
+	return &memStore{leases: map[string]models.Lease{}}
+}
+
+func (s *memStore) insert(l models.Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[l.ID]; ok {
+		return errors.New("duplicate key")
+	}
+	s.leases[l.ID] = l
+	return nil
+}
+
+func (s *memStore) get(id string) (*models.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &l, nil
+}
+
+func (s *memStore) replace(old, l models.Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.race != nil {
+		s.race(s.leases)
+	}
+	if stored, ok := s.leases[old.ID]; ok && stored.Owner == old.Owner && stored.ExpiresAt.Equal(old.ExpiresAt) {
+		s.leases[old.ID] = l
+	}
+	return nil
+}
+
+func (s *memStore) remove(id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.leases[id]; ok && stored.Owner == owner {
+		delete(s.leases, id)
+	}
+	return nil
+}
+
+func newLeases() (*Leases, *memStore, *clock.TestClock) {
+	s := newMemStore()
+	c := clock.NewTestClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	return &Leases{store: s, clock: c, ttl: time.Minute}, s, c
+}
+
+func TestTakeIsExclusiveUntilExpiry(t *testing.T) {
+	l, _, c := newLeases()
+	first, err := l.take("invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.take("invoice"); !errors.Is(err, ErrHeld) {
+		t.Fatalf("got %v, want ErrHeld", err)
+	}
+	if _, err := l.take("other"); err != nil {
+		t.Fatalf("got %v for a different id", err)
+	}
+
+	c.Advance(time.Minute + time.Second)
+	second, err := l.take("invoice")
+	if err != nil {
+		t.Fatalf("got %v taking over an expired lease", err)
+	}
+	if err := l.renew("invoice", first); !errors.Is(err, ErrLost) {
+		t.Fatalf("got %v renewing a lease taken over, want ErrLost", err)
+	}
+	if err := l.renew("invoice", second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTakeOverLosesToAConcurrentTaker(t *testing.T) {
+	l, s, c := newLeases()
+	if _, err := l.take("invoice"); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(2 * time.Minute)
+	s.race = func(leases map[string]models.Lease) {
+		leases["invoice"] = models.Lease{ID: "invoice", Owner: "someone", ExpiresAt: c.Now().Add(time.Minute), Token: "theirs"}
+	}
+	if _, err := l.take("invoice"); !errors.Is(err, ErrHeld) {
+		t.Fatalf("got %v, want ErrHeld", err)
+	}
+	if held, _ := s.get("invoice"); held.Owner != "someone" {
+		t.Fatalf("the winner's lease was overwritten by %s", held.Owner)
+	}
+}
+
+func TestRenewExtendsTheLease(t *testing.T) {
+	l, s, c := newLeases()
+	owner, err := l.take("invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(40 * time.Second)
+	if err := l.renew("invoice", owner); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := s.get("invoice"); !held.ExpiresAt.Equal(c.Now().Add(time.Minute)) {
+		t.Fatalf("got expiry %v, want %v", held.ExpiresAt, c.Now().Add(time.Minute))
+	}
+
+	// a taker that sneaks in between the read and the write wins
+	s.race = func(leases map[string]models.Lease) {
+		leases["invoice"] = models.Lease{ID: "invoice", Owner: "someone", ExpiresAt: c.Now().Add(time.Minute), Token: "theirs"}
+	}
+	if err := l.renew("invoice", owner); !errors.Is(err, ErrLost) {
+		t.Fatalf("got %v, want ErrLost", err)
+	}
+}
+
+func TestReleaseFreesOnlyItsOwnLease(t *testing.T) {
+	l, s, c := newLeases()
+	_, release, err := l.Acquire(context.Background(), "invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := s.get("invoice"); err == nil {
+		t.Fatal("lease kept after release")
+	}
+
+	_, stale, err := l.Acquire(context.Background(), "invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// taken over while the holder was stalled
+	s.leases["invoice"] = models.Lease{ID: "invoice", Owner: "someone", ExpiresAt: c.Now().Add(time.Minute), Token: "theirs"}
+	stale()
+	if held, err := s.get("invoice"); err != nil || held.Owner != "someone" {
+		t.Fatalf("a stale release removed the current lease: %v", err)
+	}
+}
+
+func TestAcquireRenewsOnTheClockUntilReleased(t *testing.T) {
+	l, s, c := newLeases()
+	held, release, err := l.Acquire(context.Background(), "invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(5 * time.Minute)
+	if _, err := l.take("invoice"); !errors.Is(err, ErrHeld) {
+		t.Fatalf("got %v, the lease lapsed while held", err)
+	}
+	if held.Err() != nil {
+		t.Fatal("held context cancelled while the lease is held")
+	}
+
+	release()
+	if held.Err() == nil {
+		t.Fatal("held context still live after release")
+	}
+	if _, err := s.get("invoice"); err == nil {
+		t.Fatal("lease kept after release")
+	}
+	c.Advance(5 * time.Minute)
+	if _, err := s.get("invoice"); err == nil {
+		t.Fatal("a released lease was renewed")
+	}
+}
+
+func TestAcquireCancelsTheHolderWhenTheLeaseIsLost(t *testing.T) {
+	l, s, c := newLeases()
+	held, release, err := l.Acquire(context.Background(), "invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	s.race = func(leases map[string]models.Lease) {
+		leases["invoice"] = models.Lease{ID: "invoice", Owner: "someone", ExpiresAt: c.Now().Add(time.Minute), Token: "theirs"}
+	}
+	c.Advance(time.Minute)
+	if held.Err() == nil {
+		t.Fatal("held context still live after the lease was lost")
+	}
+}
@@ -0,0 +1,191 @@
+package numbering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neghi-go/database"
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+)
+
+// ErrBusy is returned when the sequence stayed locked by other writers for
+// every attempt.
+var ErrBusy = errors.New("numbering: sequence is busy")
+
+// Scheme describes how invoice numbers look, e.g. INV-2026-000123.
+type Scheme struct {
+	Prefix    string
+	Separator string
+	// DateFormat is a time layout for the date part, such as "2006" or
+	// "200601". Leave it empty to omit the date.
+	DateFormat string
+	// ResetYearly restarts the sequence at 1 every year.
+	ResetYearly bool
+	// Padding is the minimum number of digits, zero padded.
+	Padding int
+}
+
+func DefaultScheme() Scheme {
+	return Scheme{
+		Prefix:      "INV",
+		Separator:   "-",
+		DateFormat:  "2006",
+		ResetYearly: true,
+		Padding:     6,
+	}
+}
+
+// Locker serializes the writers of a scope, *lease.Leases is one.
+type Locker interface {
+	Acquire(ctx context.Context, id string) (held context.Context, release func(), err error)
+}
+
+// store is the part of the counters collection a Sequence works with.
+type store interface {
+	get(scope string) (*models.Counter, error)
+	put(c models.Counter, exists bool) error
+}
+
+type modelStore struct {
+	model database.Model[models.Counter]
+}
+
+func (s modelStore) get(scope string) (*models.Counter, error) {
+	return s.model.Query(database.WithFilter("id", scope)).First()
+}
+
+func (s modelStore) put(c models.Counter, exists bool) error {
+	if !exists {
+		return s.model.Save(c)
+	}
+	return s.model.Query(database.WithFilter("id", c.ID)).Update(c)
+}
+
+// Sequence hands out invoice numbers in order and without gaps. Only one
+// writer at a time holds a scope, and the next number is reserved until the
+// invoice carrying it is written or given up, so a number is never issued
+// after a higher one. The counter remembers the reserved number as pending,
+// and when its writer died before saying how the write went, the next one
+// asks taken whether a document has it.
+type Sequence struct {
+	scheme   Scheme
+	store    store
+	locks    Locker
+	taken    func(number string) bool
+	attempts int
+	wait     time.Duration
+}
+
+func New(scheme Scheme, counters database.Model[models.Counter], locks Locker, taken func(number string) bool) *Sequence {
+	return &Sequence{
+		scheme:   scheme,
+		store:    modelStore{model: counters},
+		locks:    locks,
+		taken:    taken,
+		attempts: 50,
+		wait:     100 * time.Millisecond,
+	}
+}
+
+// Claim is a number reserved from a Sequence, it holds the scope until Done.
+type Claim struct {
+	Number  string
+	counter models.Counter
+	release func()
+}
+
+func (s *Sequence) scope(at time.Time) string {
+	if s.scheme.ResetYearly {
+		return s.scheme.Prefix + s.scheme.Separator + strconv.Itoa(at.Year())
+	}
+	return s.scheme.Prefix
+}
+
+// Reserve takes the next number for a document created at the given time.
+// The scope stays locked until Done is called with the claim, waiting for
+// other writers up to the sequence's attempts.
+func (s *Sequence) Reserve(ctx context.Context, at time.Time) (*Claim, error) {
+	at = at.UTC()
+	scope := s.scope(at)
+
+	release, err := s.lock(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	claim, err := s.reserve(scope, at)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	claim.release = release
+	return claim, nil
+}
+
+func (s *Sequence) lock(ctx context.Context, scope string) (func(), error) {
+	for range s.attempts {
+		_, release, err := s.locks.Acquire(ctx, "numbering:"+scope)
+		if err == nil {
+			return release, nil
+		}
+		if !errors.Is(err, lease.ErrHeld) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(s.wait):
+		}
+	}
+	return nil, ErrBusy
+}
+
+func (s *Sequence) reserve(scope string, at time.Time) (*Claim, error) {
+	counter := models.Counter{ID: scope, Scope: scope, CreatedAt: at}
+	exists := false
+	if head, err := s.store.get(scope); err == nil {
+		counter, exists = *head, true
+	}
+	if counter.Pending != "" {
+		// the last writer never finished, its number counts if it was used.
+		// Should the lookup miss one, the unique index refuses it again.
+		if s.taken(counter.Pending) {
+			counter.Seq++
+		}
+	}
+	counter.Pending = s.format(counter.Seq+1, at)
+	if err := s.store.put(counter, exists); err != nil {
+		return nil, err
+	}
+	return &Claim{Number: counter.Pending, counter: counter}, nil
+}
+
+// Done ends claim and unlocks its scope. written says whether the number was
+// stored, it is only issued then, otherwise the next claim gets it again. A
+// writer that can't tell leaves it to the next claim to look the number up.
+func (s *Sequence) Done(claim *Claim, written bool) error {
+	defer claim.release()
+	if !written {
+		return nil
+	}
+	counter := claim.counter
+	counter.Seq++
+	counter.Pending = ""
+	return s.store.put(counter, true)
+}
+
+func (s *Sequence) format(seq int64, at time.Time) string {
+	parts := make([]string, 0, 3)
+	if s.scheme.Prefix != "" {
+		parts = append(parts, s.scheme.Prefix)
+	}
+	if s.scheme.DateFormat != "" {
+		parts = append(parts, at.Format(s.scheme.DateFormat))
+	}
+	parts = append(parts, fmt.Sprintf("%0*d", s.scheme.Padding, seq))
+	return strings.Join(parts, s.scheme.Separator)
+}
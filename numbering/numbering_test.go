@@ -0,0 +1,157 @@
+package numbering
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/lease"
+)
+
+// memStore keeps counters in a map.
+type memStore struct {
+	counters map[string]models.Counter
+	fail     error
+}
+
+func newMemStore() *memStore {
+	return &memStore{counters: map[string]models.Counter{}}
+}
+
+func (s *memStore) get(scope string) (*models.Counter, error) {
+	c, ok := s.counters[scope]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &c, nil
+}
+
+func (s *memStore) put(c models.Counter, exists bool) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	if _, ok := s.counters[c.ID]; ok != exists {
+		return errors.New("write conflict")
+	}
+	s.counters[c.ID] = c
+	return nil
+}
+
+// memLocks hands each id to one holder at a time.
+type memLocks map[string]bool
+
+func (l memLocks) Acquire(ctx context.Context, id string) (context.Context, func(), error) {
+	if l[id] {
+		return nil, nil, lease.ErrHeld
+	}
+	l[id] = true
+	return ctx, func() { delete(l, id) }, nil
+}
+
+func sequence(store store, issued map[string]bool) *Sequence {
+	return &Sequence{
+		scheme:   DefaultScheme(),
+		store:    store,
+		locks:    memLocks{},
+		taken:    func(number string) bool { return issued[number] },
+		attempts: 3,
+		wait:     time.Millisecond,
+	}
+}
+
+var at = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// issue reserves a number and reports written for it.
+func issue(t *testing.T, s *Sequence, at time.Time, written bool) string {
+	t.Helper()
+	claim, err := s.Reserve(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Done(claim, written); err != nil {
+		t.Fatal(err)
+	}
+	return claim.Number
+}
+
+func TestReserveCountsUpPerYear(t *testing.T) {
+	s := sequence(newMemStore(), nil)
+	for _, want := range []string{"INV-2026-000001", "INV-2026-000002"} {
+		if got := issue(t, s, at, true); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+	if got := issue(t, s, at.AddDate(1, 0, 0), true); got != "INV-2027-000001" {
+		t.Fatalf("got %s, want the sequence to restart in 2027", got)
+	}
+}
+
+func TestUnwrittenNumberIsIssuedNext(t *testing.T) {
+	s := sequence(newMemStore(), nil)
+	first := issue(t, s, at, false)
+	if got := issue(t, s, at, true); got != first {
+		t.Fatalf("got %s, want the unwritten %s again", got, first)
+	}
+	if got := issue(t, s, at, true); got != "INV-2026-000002" {
+		t.Fatalf("got %s, want INV-2026-000002", got)
+	}
+}
+
+func TestReserveHoldsTheScope(t *testing.T) {
+	s := sequence(newMemStore(), nil)
+	claim, err := s.Reserve(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reserve(context.Background(), at); !errors.Is(err, ErrBusy) {
+		t.Fatalf("got %v, want ErrBusy while the first number is pending", err)
+	}
+	if _, err := s.Reserve(context.Background(), at.AddDate(1, 0, 0)); err != nil {
+		t.Fatalf("another year's scope is locked: %v", err)
+	}
+	_ = s.Done(claim, true)
+	if got := issue(t, s, at, true); got != "INV-2026-000002" {
+		t.Fatalf("got %s, want INV-2026-000002", got)
+	}
+}
+
+func TestPendingNumberIsLookedUp(t *testing.T) {
+	store := newMemStore()
+	issued := map[string]bool{}
+	s := sequence(store, issued)
+
+	// a writer that died before Done leaves its number pending
+	claim, err := s.Reserve(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim.release()
+	if got := issue(t, s, at, false); got != claim.Number {
+		t.Fatalf("got %s, want the unused %s again", got, claim.Number)
+	}
+
+	claim, _ = s.Reserve(context.Background(), at)
+	issued[claim.Number] = true
+	claim.release()
+	if got := issue(t, s, at, true); got != "INV-2026-000002" {
+		t.Fatalf("got %s, want the sequence past the written %s", got, claim.Number)
+	}
+}
+
+func TestFailedDoneLeavesTheNumberToTheLookup(t *testing.T) {
+	store := newMemStore()
+	issued := map[string]bool{}
+	s := sequence(store, issued)
+	claim, _ := s.Reserve(context.Background(), at)
+	issued[claim.Number] = true
+	store.fail = errors.New("write failed")
+	if err := s.Done(claim, true); !errors.Is(err, store.fail) {
+		t.Fatalf("got %v, want the counter write error", err)
+	}
+	store.fail = nil
+	if got := issue(t, s, at, true); got != "INV-2026-000002" {
+		t.Fatalf("got %s, want INV-2026-000002", got)
+	}
+}
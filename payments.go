@@ -1,11 +1,31 @@
 package payments
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/neghi-go/database"
 	"github.com/neghi-go/database/mongodb"
 	"github.com/neghi-go/payments/billing"
+	"github.com/neghi-go/payments/billing/dunning"
+	"github.com/neghi-go/payments/clock"
+	"github.com/neghi-go/payments/documents"
+	"github.com/neghi-go/payments/events"
+	"github.com/neghi-go/payments/internal/cardexpiry"
+	"github.com/neghi-go/payments/internal/checkout"
+	"github.com/neghi-go/payments/internal/idempotency"
 	"github.com/neghi-go/payments/internal/management"
 	"github.com/neghi-go/payments/internal/models"
+	"github.com/neghi-go/payments/internal/paylinks"
+	"github.com/neghi-go/payments/internal/poller"
+	"github.com/neghi-go/payments/internal/sweeper"
+	"github.com/neghi-go/payments/internal/testclock"
+	"github.com/neghi-go/payments/internal/webhooks"
+	"github.com/neghi-go/payments/lease"
+	"github.com/neghi-go/payments/numbering"
 	"github.com/neghi-go/payments/processors"
 )
 
@@ -13,6 +33,14 @@ type Payments struct {
 	url, database     string
 	billing           []*billing.Billing
 	payment_processor processors.Processor
+	events            *events.Bus
+	branding          documents.Branding
+	numbering         numbering.Scheme
+	checkout          *checkout.Links
+	clock             clock.Clock
+	retention         time.Duration
+	lock_ttl          time.Duration
+	started           []*billing.Billing
 }
 
 type Option func(*Payments)
@@ -36,53 +64,236 @@ func WithPaymentProcessor(pro processors.Processor) Option {
 	}
 }
 
+// WithDunning runs the dunning engine in the background once the router is built.
+func WithDunning(d *dunning.Dunning) Option {
+	return RegisterBilling(d.Billing())
+}
+
+// WithInvoiceSweeper expires overdue invoices every interval, batch invoices at a time.
+func WithInvoiceSweeper(interval time.Duration, batch int) Option {
+	return RegisterBilling(sweeper.New(interval, batch).Billing())
+}
+
+// WithCardExpiry checks saved cards every interval, publishing
+// CardExpiringSoon notice ahead of a card's expiry and deactivating it once
+// it has expired.
+func WithCardExpiry(interval, notice time.Duration) Option {
+	return RegisterBilling(cardexpiry.New(interval, notice).Billing())
+}
+
+// WithTransactionPoller re-verifies pending transactions in the background,
+// looking for due ones every interval and abandoning those older than max_age.
+// Verify calls are capped at per_second.
+func WithTransactionPoller(interval, max_age time.Duration, per_second int) Option {
+	return RegisterBilling(poller.New(
+		poller.WithInterval(interval),
+		poller.WithMaxAge(max_age),
+		poller.WithRateLimit(per_second),
+	).Billing())
+}
+
+// WithBranding sets the merchant details printed on invoice and receipt PDFs.
+// Build fails when they hold characters the PDFs can't print, see
+// documents.ErrUnprintable.
+func WithBranding(branding documents.Branding) Option {
+	return func(p *Payments) {
+		p.branding = branding
+	}
+}
+
+// WithInvoiceNumbering sets the format of invoice numbers, INV-2026-000123 by default.
+func WithInvoiceNumbering(scheme numbering.Scheme) Option {
+	return func(p *Payments) {
+		p.numbering = scheme
+	}
+}
+
+// WithCheckout serves a hosted invoice page under /checkout. Links to it are
+// signed with secret, built on base_url (where the router is reachable) and
+// stay valid for ttl.
+func WithCheckout(secret, base_url string, ttl time.Duration) Option {
+	return func(p *Payments) {
+		p.checkout = checkout.NewLinks(secret, base_url, ttl)
+	}
+}
+
+// WithClock sets where billing reads the time from. A *clock.TestClock also
+// mounts /clock, to advance it over the API, and stops background workers
+// from running on their own.
+func WithClock(c clock.Clock) Option {
+	return func(p *Payments) {
+		p.clock = c
+	}
+}
+
+// WithIdempotencyRetention sets how long responses are kept for replay to
+// requests repeating an Idempotency-Key, 24 hours by default.
+func WithIdempotencyRetention(retention time.Duration) Option {
+	return func(p *Payments) {
+		p.retention = retention
+	}
+}
+
+// WithInvoiceLockTTL sets how long a request or worker may hold an invoice
+// before others can take it over, one minute by default. Keep it above the
+// slowest processor call.
+func WithInvoiceLockTTL(ttl time.Duration) Option {
+	return func(p *Payments) {
+		p.lock_ttl = ttl
+	}
+}
+
 func New(opts ...Option) *Payments {
 	cfg := &Payments{
-		billing: make([]*billing.Billing, 0),
+		billing:   make([]*billing.Billing, 0),
+		events:    events.NewBus(),
+		numbering: numbering.DefaultScheme(),
+		clock:     clock.Real(),
+		retention: time.Hour * 24,
+		lock_ttl:  time.Minute,
 	}
-	cfg.billing = append(cfg.billing, management.NewManagement())
 
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	cfg.billing = append([]*billing.Billing{
+		management.NewManagement(
+			management.WithBranding(cfg.branding),
+			management.WithCheckout(cfg.checkout),
+		),
+		paylinks.NewLinkManagement(),
+		paylinks.NewPayLinks(cfg.branding, cfg.checkout),
+	}, cfg.billing...)
+	if cfg.checkout != nil {
+		cfg.billing = append(cfg.billing, checkout.NewCheckout(cfg.checkout, cfg.branding))
+	}
 	return cfg
 }
 
 func (p *Payments) Build() (chi.Router, error) {
+	if err := p.branding.Check(); err != nil {
+		return nil, fmt.Errorf("payments: branding: %w", err)
+	}
+	for _, b := range p.billing {
+		if b.Err != nil {
+			return nil, fmt.Errorf("payments: configuring %s: %w", b.Name, b.Err)
+		}
+	}
 	r := chi.NewRouter()
 	con, err := mongodb.New(p.url, p.database)
 	if err != nil {
 		return nil, err
 	}
-	customer, err := mongodb.RegisterModel(con, "customers", models.Customer{})
-	if err != nil {
-		return nil, err
+	var (
+		customer     = billing.Declare[models.Customer]("customers")
+		card         = billing.Declare[models.Card]("customer_cards")
+		invoice      = billing.Declare[models.Invoice]("payment_invoices")
+		transactions = billing.Declare[models.Transaction]("invoice_transactions")
+		counters     = billing.Declare[models.Counter]("invoice_counters")
+		links        = billing.Declare[models.PaymentLink]("payment_links")
+		leases       = billing.Declare[models.Lease]("invoice_leases")
+	)
+	hooks := webhooks.New()
+	keys := idempotency.New(p.clock, idempotency.WithRetention(p.retention))
+	modules := append(append([]*billing.Billing{}, p.billing...), hooks.Billing(), keys.Billing())
+	if c, ok := p.clock.(*clock.TestClock); ok {
+		modules = append(modules, testclock.NewTestClock(c, modules))
 	}
-	card, err := mongodb.RegisterModel(con, "customer_cards", models.Card{})
-	if err != nil {
-		return nil, err
+
+	// every name is checked before anything is registered on the connection
+	owners := make(map[string]string)
+	collections := []billing.Collection{customer, card, invoice, transactions, counters, links, leases}
+	for _, c := range collections {
+		owners[c.Name()] = "payments"
 	}
-	invoice, err := mongodb.RegisterModel(con, "payment_invoices", models.Invoice{})
-	if err != nil {
-		return nil, err
+	for _, b := range modules {
+		for _, c := range b.Collections {
+			if other, ok := owners[c.Name()]; ok {
+				return nil, fmt.Errorf("payments: collection %q of %s is already declared by %s", c.Name(), b.Name, other)
+			}
+			owners[c.Name()] = b.Name
+			collections = append(collections, c)
+		}
 	}
-	transactions, err := mongodb.RegisterModel(con, "invoice_transactions", models.Transaction{})
-	if err != nil {
-		return nil, err
+	for _, c := range collections {
+		if err := c.Bind(con); err != nil {
+			return nil, err
+		}
 	}
-	// register billing routes
-	for _, b := range p.billing {
+
+	leased := lease.New(leases.Model(), p.clock, p.lock_ttl)
+	taken := func(number string) bool {
+		_, err := invoice.Model().Query(database.WithFilter("number", number)).First()
+		return err == nil
+	}
+	ctx := &billing.BillingContext{
+		Customer:     customer.Model(),
+		Card:         card.Model(),
+		Invoice:      invoice.Model(),
+		Transactions: transactions.Model(),
+		Links:        links.Model(),
+		Processor:    p.payment_processor,
+		Events:       p.events,
+		Numbering:    numbering.New(p.numbering, counters.Model(), leased, taken),
+		Clock:        p.clock,
+		Leases:       leased,
+	}
+	for _, b := range modules {
+		if b.BeforeCharge != nil {
+			ctx.OnBeforeCharge(b.BeforeCharge)
+		}
+		if b.AfterPayment != nil {
+			ctx.OnAfterPayment(b.AfterPayment)
+		}
+		if b.Reference != nil {
+			ctx.SetReferences(b.Reference)
+		}
+	}
+	r.Use(keys.Middleware)
+	// register billing routes, modules without any only run in the background
+	for _, b := range modules {
+		if b.Init == nil {
+			continue
+		}
 		route := chi.NewRouter()
-		b.Init(route, &billing.BillingContext{
-			Customer:     customer,
-			Card:         card,
-			Invoice:      invoice,
-			Transactions: transactions,
-			Processor:    p.payment_processor,
-		})
+		route.Use(b.Middleware...)
+		b.Init(route, ctx)
 
 		r.Mount("/"+b.Name, route)
 	}
 
+	for _, b := range modules {
+		if b.Start != nil {
+			if err := b.Start(context.Background(), ctx); err != nil {
+				p.Close()
+				return nil, fmt.Errorf("payments: starting %s: %w", b.Name, err)
+			}
+		}
+		p.started = append(p.started, b)
+	}
+
 	return r, nil
 }
+
+// Events returns the bus payment lifecycle events are published on.
+func (p *Payments) Events() *events.Bus {
+	return p.events
+}
+
+// Close stops the modules started by Build, last started first, and waits
+// for asynchronous event subscribers to finish.
+func (p *Payments) Close() error {
+	var errs []error
+	for i := len(p.started) - 1; i >= 0; i-- {
+		b := p.started[i]
+		if b.Stop == nil {
+			continue
+		}
+		if err := b.Stop(context.Background()); err != nil {
+			errs = append(errs, fmt.Errorf("payments: stopping %s: %w", b.Name, err))
+		}
+	}
+	p.started = nil
+	p.events.Wait()
+	return errors.Join(errs...)
+}
@@ -16,7 +16,7 @@ func (f *Flutterwave) Charge(ctx context.Context, email string, amount int64, ca
 }
 
 // Init implements processors.Processor.
-func (f *Flutterwave) Init(ctx context.Context, email string, amount int64, reference string) (string, error) {
+func (f *Flutterwave) Init(ctx context.Context, email string, amount int64, reference string, callback_url string) (string, error) {
 	panic("unimplemented")
 }
 
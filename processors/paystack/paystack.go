@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,12 +18,14 @@ var (
 	base_url       = "https://api.paystack.co"
 	initialize_url = "/transaction/initialize"
 	verify_url     = "/transaction/verify"
+	customer_url   = "/customer"
 	charge_url     = ""
 	refund_url     = ""
 )
 
 type Paystack struct {
-	key string
+	key          string
+	callback_url string
 }
 
 type Option func(*Paystack)
@@ -176,20 +179,25 @@ func (p *Paystack) Charge(ctx context.Context, email string, amount int64, card_
 }
 
 // Init implements processors.Processor.
-func (p *Paystack) Init(ctx context.Context, email string, amount int64, reference string) (string, error) {
+func (p *Paystack) Init(ctx context.Context, email string, amount int64, reference string, callback_url string) (string, error) {
 	var res_body initiateResponse
+	if callback_url == "" {
+		callback_url = p.callback_url
+	}
 	buf := &bytes.Buffer{}
 
 	body := struct {
-		Email     string   `json:"email"`
-		Amount    int64    `json:"amount"`
-		Reference string   `json:"reference"`
-		Channels  []string `json:"channels"`
+		Email       string   `json:"email"`
+		Amount      int64    `json:"amount"`
+		Reference   string   `json:"reference"`
+		Channels    []string `json:"channels"`
+		CallbackURL string   `json:"callback_url,omitempty"`
 	}{
-		Email:     email,
-		Amount:    amount,
-		Reference: reference,
-		Channels:  []string{"card"},
+		Email:       email,
+		Amount:      amount,
+		Reference:   reference,
+		Channels:    []string{"card"},
+		CallbackURL: callback_url,
 	}
 
 	err := json.NewEncoder(buf).Encode(body)
@@ -257,6 +265,9 @@ func (p *Paystack) Verify(ctx context.Context, trx_id string) (processors.Verify
 	}
 
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return 0, processors.ErrRateLimited
+	}
 	if err := json.NewDecoder(res.Body).Decode(&res_body); err != nil {
 		return 0, err
 	}
@@ -285,6 +296,90 @@ func (p *Paystack) Verify(ctx context.Context, trx_id string) (processors.Verify
 	return r, nil
 }
 
+// Authorization implements processors.Authorizer.
+func (p *Paystack) Authorization(ctx context.Context, trx_id string) (*processors.Card, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base_url+verify_url+"/"+trx_id, nil)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+p.key)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, processors.ErrRateLimited
+	}
+	var res_body trxResponse
+	if err := json.NewDecoder(res.Body).Decode(&res_body); err != nil {
+		return nil, err
+	}
+	if !res_body.Status {
+		return nil, errors.New("paystack: we had an issue verifying that transaction")
+	}
+
+	auth := res_body.Data.Authorization
+	if auth.AuthorizationCode == "" || auth.Channel != "card" {
+		return nil, nil
+	}
+	month, _ := strconv.Atoi(auth.ExpMonth)
+	year, _ := strconv.Atoi(auth.ExpYear)
+	return &processors.Card{
+		AuthKey:  auth.AuthorizationCode,
+		Brand:    auth.Brand,
+		Last4:    auth.Last4,
+		ExpMonth: month,
+		ExpYear:  year,
+		Bank:     auth.Bank,
+
+		CustomerCode: res_body.Data.Customer.CustomerCode,
+	}, nil
+}
+
+// UpdateCustomer implements processors.CustomerUpdater. Paystack keys
+// customers on their email, which can't be changed, so Paystack is not a
+// processors.EmailUpdater and customer.Email is not sent.
+func (p *Paystack) UpdateCustomer(ctx context.Context, customer_code string, customer *processors.Customer) error {
+	buf := &bytes.Buffer{}
+	body := struct {
+		FirstName string            `json:"first_name"`
+		LastName  string            `json:"last_name"`
+		Phone     string            `json:"phone,omitempty"`
+		Metadata  map[string]string `json:"metadata,omitempty"`
+	}{
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		Phone:     customer.Phone,
+		Metadata:  customer.Metadata,
+	}
+	if err := json.NewEncoder(buf).Encode(body); err != nil {
+		return err
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, base_url+customer_url+"/"+customer_code, buf)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+p.key)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return processors.ErrRateLimited
+	}
+	var res_body struct {
+		Status  bool   `json:"status"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&res_body); err != nil {
+		return err
+	}
+	if !res_body.Status {
+		return fmt.Errorf("paystack: updating customer: %s", res_body.Message)
+	}
+	return nil
+}
+
 func (p *Paystack) Webhook(ctx context.Context, r *http.Request) error { return nil }
 
 func SetKey(key string) Option {
@@ -293,6 +388,15 @@ func SetKey(key string) Option {
 	}
 }
 
+// SetCallbackURL sets where customers are sent after paying, overriding the
+// callback URL on the Paystack dashboard. A callback_url passed to Init takes
+// precedence.
+func SetCallbackURL(url string) Option {
+	return func(p *Paystack) {
+		p.callback_url = url
+	}
+}
+
 func New(opts ...Option) *Paystack {
 	cfg := &Paystack{}
 	for _, opt := range opts {
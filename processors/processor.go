@@ -2,11 +2,16 @@ package processors
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/google/uuid"
 )
 
+// ErrRateLimited is returned by processors when the provider asks callers to
+// slow down, e.g. with a 429.
+var ErrRateLimited = errors.New("processors: rate limited by the provider")
+
 type VerifyState int
 
 const (
@@ -18,9 +23,54 @@ const (
 )
 
 type Processor interface {
-	Init(ctx context.Context, email string, amount int64, reference string) (string, error)
+	// Init starts a hosted payment and returns the URL to send the customer to.
+	// The processor sends them back to callback_url, or to its configured
+	// callback when callback_url is empty.
+	Init(ctx context.Context, email string, amount int64, reference string, callback_url string) (string, error)
 	Charge(ctx context.Context, email string, amount int64, card_token string, reference string) error
 	Verify(ctx context.Context, trx_id string) (VerifyState, error)
 	Webhook(ctx context.Context, r *http.Request) error
 	Refund(ctx context.Context, trx_id uuid.UUID) error
 }
+
+// Card describes the reusable card a payment was made with.
+type Card struct {
+	AuthKey  string
+	Brand    string
+	Last4    string
+	ExpMonth int
+	ExpYear  int
+	Bank     string
+	// CustomerCode is the processor's reference for the paying customer.
+	CustomerCode string
+}
+
+// Authorizer is implemented by processors that can hand back the card a
+// verified payment used, so it can be charged again later. It returns nil
+// when the payment's method cannot be reused.
+type Authorizer interface {
+	Authorization(ctx context.Context, trx_id string) (*Card, error)
+}
+
+// Customer is the profile pushed to a processor customer.
+type Customer struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Phone     string
+	Metadata  map[string]string
+}
+
+// CustomerUpdater is implemented by processors that keep their own customer
+// records, so profile changes can be pushed to them.
+type CustomerUpdater interface {
+	UpdateCustomer(ctx context.Context, customer_code string, customer *Customer) error
+}
+
+// EmailUpdater is implemented by customer updaters whose UpdateCustomer also
+// changes the email. A linked customer's email can't be changed on any other
+// processor, as the two copies would no longer match.
+type EmailUpdater interface {
+	CustomerUpdater
+	UpdatesEmail()
+}